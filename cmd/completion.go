@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"strings"
+)
+
+// The completion command itself (runcontainer completion bash|zsh|fish|powershell) is provided by cobra, the
+// functions below complete the values specific to runcontainer.
+
+// completeProfiles returns the profiles defined in the config file.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	_, dockerConfigurations, err := loadCompletionConfigurations()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return dockerConfigurations.CompleteProfiles(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeConfigFiles returns the config files (json).
+func completeConfigFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"json"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeContainerCommand returns the executables available in the profile image for the command and let the shell
+// complete the files for its arguments.
+func completeContainerCommand(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+
+	dockerConfigurationsFileName, dockerConfigurations, err := loadCompletionConfigurations()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	dockerConfiguration, err := resolveProfile(dockerConfigurationsFileName, dockerConfigurations, getProfileName(dockerConfigurations))
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	// The completion cannot ask for trust, the image of a config file that has not been trusted is never run
+	executables, err := dockerConfiguration.GetImageExecutables(isTrusted(dockerConfigurationsFileName, dockerConfigurations))
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var result []string
+	for _, executable := range executables {
+		if strings.HasPrefix(executable, toComplete) {
+			result = append(result, executable)
+		}
+	}
+	return result, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTasks returns the tasks of the profile with their description.
+func completeTasks(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+
+	dockerConfiguration, err := loadCompletionProfile()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return dockerConfiguration.CompleteTasks(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func loadCompletionConfigurations() (string, *runcontainer.DockerConfigs, error) {
+	// The config file flag is only known once the completion command parsed the command line
+	initConfig()
+	return loadConfigurations()
+}
+
+func loadCompletionProfile() (*runcontainer.DockerConfig, error) {
+	dockerConfigurationsFileName, dockerConfigurations, err := loadCompletionConfigurations()
+	if err != nil {
+		return nil, err
+	}
+	return resolveProfile(dockerConfigurationsFileName, dockerConfigurations, getProfileName(dockerConfigurations))
+}
+
+// completeValues returns a completion function proposing fixed values.
+func completeValues(values []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loadConfigurations reads the configuration file found by viper and returns its name and content.
+func loadConfigurations() (string, *runcontainer.DockerConfigs, error) {
+	// If a config file is found, read it in.
+	if err := viper.ReadInConfig(); err != nil {
+		return "", nil, fmt.Errorf("unable to find config file to use")
+	}
+	dockerConfigurationsFileName := viper.ConfigFileUsed()
+	logrus.Infof("Using config file %s", dockerConfigurationsFileName)
+
+	dockerConfigurations, err := readConfigurations(dockerConfigurationsFileName)
+	if err != nil {
+		return "", nil, err
+	}
+	return dockerConfigurationsFileName, dockerConfigurations, nil
+}
+
+// readConfigurations reads a configuration file.
+func readConfigurations(dockerConfigurationsFileName string) (*runcontainer.DockerConfigs, error) {
+	var dockerConfigurations runcontainer.DockerConfigs
+
+	//ignore viper for actual value
+	dockerConfigurationsBytes, err := ioutil.ReadFile(dockerConfigurationsFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(dockerConfigurationsBytes, &dockerConfigurations)
+	if err != nil {
+		return nil, fmt.Errorf("unable to deserialize configuration file %s: %v", dockerConfigurationsFileName, err)
+	}
+
+	return &dockerConfigurations, nil
+}
+
+// getProfileName returns the profile selected on the command line or the default one.
+func getProfileName(dockerConfigurations *runcontainer.DockerConfigs) string {
+	profile := cfgProfile
+	if profile == "" {
+		profile = dockerConfigurations.DefaultProfile
+	}
+
+	if profile == "" {
+		profile = "default"
+	}
+	return profile
+}
+
+// loadTrustedProfile reads the configuration file, ensures that the user trusts it and returns the selected profile.
+func loadTrustedProfile() (string, *runcontainer.DockerConfigs, *runcontainer.DockerConfig, error) {
+	dockerConfigurationsFileName, dockerConfigurations, err := loadConfigurations()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if err := checkTrust(dockerConfigurationsFileName, dockerConfigurations); err != nil {
+		return "", nil, nil, err
+	}
+
+	dockerConfiguration, err := resolveProfile(dockerConfigurationsFileName, dockerConfigurations, getProfileName(dockerConfigurations))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return dockerConfigurationsFileName, dockerConfigurations, dockerConfiguration, nil
+}
+
+// getRunOptions returns the options of the runners used by the commands.
+func getRunOptions() []runcontainer.Option {
+	var options []runcontainer.Option
+	if timingsOption := getTimingsOption(); timingsOption != nil {
+		options = append(options, timingsOption)
+	}
+	if runTimeout > 0 {
+		options = append(options, runcontainer.WithTimeout(runTimeout))
+	}
+	if lockTimeout > 0 {
+		options = append(options, runcontainer.WithLockTimeout(lockTimeout))
+	}
+
+	auditLog, err := loadAuditLog()
+	if err != nil {
+		logrus.Warningf("Runs are not recorded in the audit log: %v", err)
+		return options
+	}
+	return append(options, runcontainer.WithAuditLog(auditLog))
+}
+
+// loadAuditLog returns the audit log with the retention of the config file of the home directory, if any. The
+// retention of the config file of a project is ignored so that it cannot erase the runs recorded by the user.
+func loadAuditLog() (*runcontainer.AuditLog, error) {
+	path, err := runcontainer.DefaultAuditLogPath()
+	if err != nil {
+		return nil, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var config *runcontainer.AuditConfig
+	dockerConfigurations, err := readConfigurations(filepath.Join(home, ".runcontainer.json"))
+	if err == nil {
+		config = dockerConfigurations.Audit
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return runcontainer.NewAuditLog(path, config)
+}
+
+// resolveProfile returns a profile of the configuration file with the default values applied.
+func resolveProfile(dockerConfigurationsFileName string, dockerConfigurations *runcontainer.DockerConfigs, profile string) (*runcontainer.DockerConfig, error) {
+	dockerConfiguration := dockerConfigurations.Configs[profile]
+	if dockerConfiguration == nil {
+		return nil, fmt.Errorf("profile %s not found in %s", profile, dockerConfigurationsFileName)
+	}
+	logrus.Infof("Using profile %s", profile)
+
+	if dockerConfiguration.TempDirMountLocation == "" {
+		dockerConfiguration.TempDirMountLocation = runcontainer.MountLocHost
+	}
+	if dockerConfiguration.DockerOptions == nil {
+		dockerConfiguration.DockerOptions = []string{}
+	}
+	if dockerConfiguration.RunBeforeCommands == nil {
+		dockerConfiguration.RunBeforeCommands = []string{}
+	}
+	if dockerConfiguration.RunAfterCommands == nil {
+		dockerConfiguration.RunAfterCommands = []string{}
+	}
+	if dockerConfiguration.Environment == nil {
+		dockerConfiguration.Environment = map[string]string{}
+	}
+	dockerConfiguration.Environment["RUNCONTAINER_PROFILE"] = profile
+	dockerConfiguration.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"] = dockerConfigurationsFileName
+
+	if dockerConfiguration.MountPoint == "" {
+		dockerConfiguration.MountPoint = "current_sources"
+	}
+
+	return dockerConfiguration, nil
+}
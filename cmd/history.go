@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var historySince string
+var historyJson bool
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the runs recorded in the audit log",
+	Long: `Show the runs recorded in the audit log, optionally limited to a profile and to the runs since a
+duration (i.e. 24h) or a date (i.e. 2021-07-01):
+	runcontainer history --profile iac --since 24h`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseSince(historySince, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		auditLog, err := loadAuditLog()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		entries, err := auditLog.Entries()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		var selected []runcontainer.AuditEntry
+		for _, entry := range entries {
+			if (cfgProfile == "" || entry.Profile == cfgProfile) && !entry.Time.Before(since) {
+				selected = append(selected, entry)
+			}
+		}
+
+		if historyJson {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, entry := range selected {
+				encoder.Encode(entry)
+			}
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "TIME\tPROFILE\tIMAGE\tEXIT CODE\tDURATION\tCOMMAND")
+		for _, entry := range selected {
+			duration := time.Duration(entry.Duration * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%v\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Profile, entry.Image, entry.ExitCode, duration, strings.Join(entry.Args, " "))
+		}
+		writer.Flush()
+	},
+}
+
+// parseSince returns the time corresponding to a duration before now or to a date.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if since, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return since, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, expected a duration (i.e. 24h) or a date (i.e. 2021-07-01)", value)
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show the runs since a duration (i.e. 24h) or a date (i.e. 2021-07-01)")
+	historyCmd.Flags().BoolVar(&historyJson, "json", false, "print the entries as JSON lines")
+}
@@ -50,6 +50,17 @@ var initCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// The generated configuration is trusted as it has been created by the user
+		store, err := loadTrustStore()
+		if err == nil {
+			store.Trust(dockerConfigurationsFileName, dockerConfigurations)
+			err = store.Save()
+		}
+		if err != nil {
+			os.Stderr.WriteString(err.Error())
+			os.Exit(1)
+		}
+
 		fmt.Fprintf(os.Stdout, "Created %s\n", dockerConfigurationsFileName)
 		os.Exit(1)
 	},
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var logVerbose bool
+var logLevel string
+var logFormat string
+
+// initLogging configures the logger from the flags. The logs are written to stderr so they never mix with the
+// output of the container.
+func initLogging() {
+	logrus.SetOutput(os.Stderr)
+
+	level, err := getLogLevel(logLevel, logVerbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logrus.SetLevel(level)
+
+	switch logFormat {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		fmt.Fprintf(os.Stderr, "invalid log format %q, expected text or json\n", logFormat)
+		os.Exit(1)
+	}
+}
+
+// getLogLevel returns the level of the --log-level flag, --verbose logs at least the debug messages.
+func getLogLevel(name string, verbose bool) (logrus.Level, error) {
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return level, err
+	}
+	if verbose && level < logrus.DebugLevel {
+		level = logrus.DebugLevel
+	}
+	return level, nil
+}
+
+func init() {
+	cobra.OnInitialize(initLogging)
+
+	rootCmd.PersistentFlags().BoolVarP(&logVerbose, "verbose", "v", false, "log the details of the run (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "warning", "log level (trace, debug, info, warning, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format (text or json)")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("log-level", completeValues([]string{"trace", "debug", "info", "warning", "error"})))
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("log-format", completeValues([]string{"text", "json"})))
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+func TestGetLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		verbose bool
+		want    logrus.Level
+		wantErr bool
+	}{
+		{"warning", false, logrus.WarnLevel, false},
+		{"info", false, logrus.InfoLevel, false},
+		{"ERROR", false, logrus.ErrorLevel, false},
+		{"warning", true, logrus.DebugLevel, false},
+		{"trace", true, logrus.TraceLevel, false},
+		{"loud", false, 0, true},
+		{"loud", true, 0, true},
+	}
+	for _, tt := range tests {
+		level, err := getLogLevel(tt.name, tt.verbose)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("getLogLevel(%s, %t) error = %v, want error %t", tt.name, tt.verbose, err, tt.wantErr)
+		} else if err == nil && level != tt.want {
+			t.Errorf("getLogLevel(%s, %t) = %v, want %v", tt.name, tt.verbose, level, tt.want)
+		}
+	}
+}
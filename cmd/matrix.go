@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var matrixProfiles []string
+var matrixParallel int
+var matrixCollect bool
+
+// matrixCmd represents the matrix command
+var matrixCmd = &cobra.Command{
+	Use:   "matrix [--profiles a,b,c] -- command [args]",
+	Short: "Run the same command with several profiles or image tags",
+	Long: `Run the same command for several profiles and for the combinations of image tags and environment
+values defined in the matrix of each profile, then print a summary of the exit codes and durations:
+	runcontainer matrix --profiles tf-1.0,tf-1.1 -- terraform validate`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dockerConfigurationsFileName, dockerConfigurations, err := loadConfigurations()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := checkTrust(dockerConfigurationsFileName, dockerConfigurations); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		profiles := matrixProfiles
+		if len(profiles) == 0 {
+			profiles = []string{getProfileName(dockerConfigurations)}
+		}
+
+		var runs []*runcontainer.MatrixRun
+		for _, profile := range profiles {
+			dockerConfiguration, err := resolveProfile(dockerConfigurationsFileName, dockerConfigurations, profile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			runs = append(runs, dockerConfiguration.GetMatrixRuns(profile)...)
+		}
+
+		// Handle eventual panic message
+		defer exitOnPanic()
+
+		exitCode := runcontainer.RunMatrix(runs, args, matrixParallel, matrixCollect, os.Stdout, os.Stderr, getRunOptions()...)
+
+		fmt.Fprintln(os.Stderr)
+		writer := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "RUN\tEXIT CODE\tDURATION")
+		for _, run := range runs {
+			fmt.Fprintf(writer, "%s\t%d\t%v\n", run.Name, run.ExitCode, run.Duration.Round(time.Millisecond))
+		}
+		writer.Flush()
+
+		os.Exit(exitCode)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(matrixCmd)
+
+	matrixCmd.Flags().StringSliceVar(&matrixProfiles, "profiles", nil, "profiles to run (default is the selected profile)")
+	matrixCmd.Flags().IntVar(&matrixParallel, "parallel", 2, "maximum number of runs at the same time")
+	matrixCmd.Flags().BoolVar(&matrixCollect, "collect", false, "print the output of each run when it completes instead of prefixing its lines")
+	matrixCmd.Flags().SetInterspersed(false)
+	cobra.CheckErr(matrixCmd.RegisterFlagCompletionFunc("profiles", completeProfiles))
+}
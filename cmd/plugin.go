@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"os"
+	"text/tabwriter"
+)
+
+// pluginsCmd represents the plugins command
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins",
+	Short: "List the plugins available as subcommands",
+	Long: `Plugins are executables named runcontainer-<name> found in '$HOME/.runcontainer/plugins' or on the PATH. They
+are run by 'runcontainer <name>' with the resolved profile serialized as JSON in RUNCONTAINER_PROFILE_JSON.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "PLUGIN\tPATH")
+		for _, plugin := range runcontainer.ListPlugins(getPluginFolder()) {
+			fmt.Fprintf(writer, "%s\t%s\n", plugin.Name, plugin.Path)
+		}
+		writer.Flush()
+	},
+}
+
+// findPlugin returns the plugin matching the first argument. Arguments following -- are always run in the container.
+func findPlugin(cmd *cobra.Command, args []string) *runcontainer.Plugin {
+	if len(args) == 0 || cmd.ArgsLenAtDash() == 0 {
+		return nil
+	}
+	return runcontainer.FindPlugin(getPluginFolder(), args[0])
+}
+
+// runPlugin runs the plugin with the profile when a config file is found, plugins can also be used without one. The
+// profile is only given to the plugin once the config file is trusted, as for a container run.
+func runPlugin(plugin *runcontainer.Plugin, args []string) {
+	logrus.Infof("Running plugin %s, use 'runcontainer -- %s' to run %s in the container instead", plugin.Path, plugin.Name, plugin.Name)
+
+	var dockerConfiguration *runcontainer.DockerConfig
+	if dockerConfigurationsFileName, dockerConfigurations, err := loadConfigurations(); err == nil {
+		if err := checkTrust(dockerConfigurationsFileName, dockerConfigurations); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		dockerConfiguration, err = resolveProfile(dockerConfigurationsFileName, dockerConfigurations, getProfileName(dockerConfigurations))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	exitCode, err := plugin.Run(dockerConfiguration, runcontainer.StandardStreams(), args...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode)
+}
+
+func getPluginFolder() string {
+	folder, err := runcontainer.DefaultPluginFolder()
+	if err != nil {
+		return ""
+	}
+	return folder
+}
+
+func init() {
+	rootCmd.AddCommand(pluginsCmd)
+}
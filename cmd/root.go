@@ -1,18 +1,19 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/taliesins/runcontainer/runcontainer"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var cfgFile string
 var cfgProfile string
+var runTimeout time.Duration
+var lockTimeout time.Duration
 
 
 // rootCmd represents the base command when called without any subcommands
@@ -22,96 +23,42 @@ var rootCmd = &cobra.Command{
 	Long: `
 Map current path and home directory into container
 Map env variables into container
+
+Arguments are run in the container instead of the configured entry point:
+	runcontainer --profile iac -- terraform plan
+
+Unknown subcommands run the runcontainer-<name> plugin when there is one, see 'runcontainer plugins'.
 `,
+	Args: cobra.ArbitraryArgs,
 
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-
-		// If a config file is found, read it in.
-		if err := viper.ReadInConfig(); err == nil {
-			fmt.Fprintln(os.Stdout, "Using config file:", viper.ConfigFileUsed())
-		} else {
-			fmt.Fprintln(os.Stderr, "Unable to find config file to use")
-			os.Exit(1)
+		if plugin := findPlugin(cmd, args); plugin != nil {
+			runPlugin(plugin, args[1:])
 		}
-		dockerConfigurationsFileName := viper.ConfigFileUsed()
-
-		var dockerConfigurations runcontainer.DockerConfigs
 
-		//ignore viper for actual value
-		dockerConfigurationsBytes, err := ioutil.ReadFile(dockerConfigurationsFileName)
+		_, _, dockerConfiguration, err := loadTrustedProfile()
 		if err != nil {
-			os.Stderr.WriteString(err.Error())
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
-		err = json.Unmarshal(dockerConfigurationsBytes, &dockerConfigurations)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "unable to deserialize configuration file ", err)
-			os.Exit(1)
-		}
-
-		profile := cfgProfile
-		if profile == "" {
-			profile = dockerConfigurations.DefaultProfile
-		}
-
-		if profile == "" {
-			profile = "default"
-		}
-
-		dockerConfiguration := dockerConfigurations.Configs[profile]
-		if dockerConfiguration.TempDirMountLocation == "" {
-			dockerConfiguration.TempDirMountLocation = runcontainer.MountLocHost
-		}
-		if dockerConfiguration.DockerOptions == nil {
-			dockerConfiguration.DockerOptions = []string{}
-		}
-		if dockerConfiguration.RunBeforeCommands == nil {
-			dockerConfiguration.RunBeforeCommands = []string{}
-		}
-		if dockerConfiguration.RunAfterCommands == nil {
-			dockerConfiguration.RunAfterCommands = []string{}
-		}
-		if dockerConfiguration.Environment == nil {
-			dockerConfiguration.Environment = map[string]string{}
-		}
-		dockerConfiguration.Environment["RUNCONTAINER_PROFILE"] = profile
-		dockerConfiguration.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"] = dockerConfigurationsFileName
-
-		workingDirectory, err := os.Getwd()
-
-
-		// Search from current working directory and up for a file with name ".runcontainer" (without extension).
-		oldWorkingDirectory := ""
-		for {
-			viper.AddConfigPath(workingDirectory)
-			oldWorkingDirectory = workingDirectory
-			workingDirectory = filepath.Dir(workingDirectory)
-
-			if workingDirectory == "" || workingDirectory == "." || oldWorkingDirectory == workingDirectory {
-				break
-			}
-		}
-
-
-		if dockerConfiguration.MountPoint == "" {
-			dockerConfiguration.MountPoint = "current_sources"
-		}
-
 		// Handle eventual panic message
-		defer func() {
-			if err := recover().(error); err != nil {
-				os.Stderr.WriteString(err.Error())
-				os.Exit(1)
-			}
-		}()
+		defer exitOnPanic()
 
-		os.Exit(dockerConfiguration.Execute())
+		os.Exit(dockerConfiguration.ExecuteWithOptions(args, getRunOptions()...))
 	},
 }
 
+// exitOnPanic prints the message of a panic raised while running a container and exits.
+func exitOnPanic() {
+	if err := recover(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -129,6 +76,14 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is '$CWD/.runcontainer.json,$HOME/.runcontainer.json')")
 	rootCmd.PersistentFlags().StringVar(&cfgProfile, "profile", "", "profile to use (default is 'default')")
+	rootCmd.PersistentFlags().DurationVar(&runTimeout, "timeout", 0, "stop the container and fail the run after this duration (default is the profile timeout)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", 0, "maximum time waiting for the lock of the profile (default is to wait until it is released)")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("config", completeConfigFiles))
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles))
+	rootCmd.ValidArgsFunction = completeContainerCommand
+
+	// Flags following the container command belong to that command
+	rootCmd.Flags().SetInterspersed(false)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -136,6 +91,7 @@ func initConfig() {
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
+		logrus.Debugf("Using config file %s from the command line", cfgFile)
 	} else {
 		// Find in current directory.
 		workingDirectory, err := os.Getwd()
@@ -148,6 +104,7 @@ func initConfig() {
 		// Search from current working directory and up for a file with name ".runcontainer" (without extension).
 		oldWorkingDirectory := ""
 		for {
+			logrus.Debugf("Searching config file in %s", workingDirectory)
 			viper.AddConfigPath(workingDirectory)
 			oldWorkingDirectory = workingDirectory
 			workingDirectory = filepath.Dir(workingDirectory)
@@ -158,6 +115,7 @@ func initConfig() {
 		}
 
 		// Search config in home directory with name ".runcontainer" (without extension).
+		logrus.Debugf("Searching config file in %s", home)
 		viper.AddConfigPath(home)
 		viper.SetConfigType("json")
 		viper.SetConfigName(".runcontainer")
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run <task> [args]",
+	Short: "Run a task of the profile",
+	Long: `Run a task defined in the tasks of the profile, after the tasks it depends on.
+The args are appended to the task command, or given as "$@" if the command uses
+them itself (i.e. make "$@" && make install).`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, dockerConfiguration, err := loadTrustedProfile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		// Handle eventual panic message
+		defer exitOnPanic()
+
+		os.Exit(dockerConfiguration.RunTask(args[0], args[1:], getRunOptions()...))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	// Flags following the task name belong to the task
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.ValidArgsFunction = completeTasks
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+var shimFolder string
+
+// shimCmd represents the shim command
+var shimCmd = &cobra.Command{
+	Use:   "shim",
+	Short: "Manage host wrappers that run commands in the container",
+	Long: `Shims are small executables that run a command in the container of a profile, forwarding the
+arguments, stdin and exit code. Add the shim folder to your PATH to use them as native commands:
+	runcontainer shim install terraform terragrunt tflint --profile iac`,
+}
+
+var shimInstallCmd = &cobra.Command{
+	Use:   "install <command>...",
+	Short: "Install shims for commands",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		folder := getShimFolder()
+
+		configFile := cfgFile
+		if configFile != "" {
+			absolute, err := filepath.Abs(configFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			configFile = absolute
+		}
+
+		for _, command := range args {
+			fileName, err := runcontainer.InstallShim(folder, runcontainer.Shim{Command: command, Profile: cfgProfile, ConfigFile: configFile})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Installed %s\n", fileName)
+		}
+
+		if !listContainsPath(filepath.SplitList(os.Getenv("PATH")), folder) {
+			fmt.Fprintf(os.Stderr, "%s is not in your PATH\n", folder)
+		}
+	},
+}
+
+var shimListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the installed shims",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		shims, err := runcontainer.ListShims(getShimFolder())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "SHIM\tCOMMAND\tPROFILE\tCONFIG")
+		for _, shim := range shims {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", shim.Name, shim.Command, shim.Profile, shim.ConfigFile)
+		}
+		writer.Flush()
+	},
+}
+
+var shimRemoveCmd = &cobra.Command{
+	Use:   "remove <command>...",
+	Short: "Remove shims",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		folder := getShimFolder()
+		for _, command := range args {
+			if err := runcontainer.RemoveShim(folder, command); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Removed %s\n", command)
+		}
+	},
+}
+
+func getShimFolder() string {
+	if shimFolder != "" {
+		return shimFolder
+	}
+	folder, err := runcontainer.DefaultShimFolder()
+	cobra.CheckErr(err)
+	return folder
+}
+
+func listContainsPath(list []string, folder string) bool {
+	for _, item := range list {
+		if strings.EqualFold(filepath.Clean(item), filepath.Clean(folder)) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(shimCmd)
+	shimCmd.AddCommand(shimInstallCmd, shimListCmd, shimRemoveCmd)
+
+	shimCmd.PersistentFlags().StringVar(&shimFolder, "bin-dir", "", "folder containing the shims (default is '$HOME/.runcontainer/bin')")
+	shimInstallCmd.ValidArgsFunction = completeContainerCommand
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// tasksCmd represents the tasks command
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "List the tasks of the profile",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dockerConfigurationsFileName, dockerConfigurations, err := loadConfigurations()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		dockerConfiguration, err := resolveProfile(dockerConfigurationsFileName, dockerConfigurations, getProfileName(dockerConfigurations))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "TASK\tDESCRIPTION\tDEPENDS ON")
+		for _, name := range dockerConfiguration.GetTaskNames() {
+			task := dockerConfiguration.Tasks[name]
+			if task == nil {
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", name, task.Description, strings.Join(task.DependsOn, ", "))
+		}
+		writer.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tasksCmd)
+}
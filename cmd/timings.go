@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// processStart is used to measure the time spent finding and loading the configuration.
+var processStart = time.Now()
+
+var showTimings bool
+var metricsFile string
+var metricsFormat string
+
+// getTimingsOption returns the runner option reporting the timings of the runs, if requested.
+func getTimingsOption() runcontainer.Option {
+	if !showTimings && metricsFile == "" {
+		return nil
+	}
+
+	configDuration := time.Since(processStart)
+	var results []runcontainer.Result
+	var lock sync.Mutex
+
+	return runcontainer.WithResultHandler(func(result runcontainer.Result) {
+		result.Timings = append(runcontainer.Timings{{Phase: runcontainer.PhaseConfig, Duration: configDuration}}, result.Timings...)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if showTimings {
+			printTimings(os.Stderr, result)
+		}
+		if metricsFile != "" {
+			results = append(results, result)
+			if err := runcontainer.WriteMetrics(metricsFile, runcontainer.MetricsFormat(metricsFormat), results); err != nil {
+				logrus.Warningf("Unable to write the metrics: %v", err)
+			}
+		}
+	})
+}
+
+func printTimings(out io.Writer, result runcontainer.Result) {
+	title := result.Image
+	if result.Run != "" {
+		title = result.Run
+	}
+	fmt.Fprintf(out, "\nTimings of %s:\n", title)
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, timing := range result.Timings {
+		fmt.Fprintf(writer, "%s\t%v\t\n", timing.Phase, timing.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(writer, "total\t%v\t\n", result.Timings.Total().Round(time.Millisecond))
+	writer.Flush()
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&showTimings, "timings", false, "print the time spent in each phase of the run")
+	rootCmd.PersistentFlags().StringVar(&metricsFile, "metrics-file", "", "write the timings of the run to a Prometheus textfile or OpenMetrics file")
+	rootCmd.PersistentFlags().StringVar(&metricsFormat, "metrics-format", string(runcontainer.MetricsPrometheus), "format of the metrics file (prometheus or openmetrics)")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("metrics-format", completeValues([]string{string(runcontainer.MetricsPrometheus), string(runcontainer.MetricsOpenMetrics)})))
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"os"
+	"strings"
+)
+
+// trustCmd represents the trust command
+var trustCmd = &cobra.Command{
+	Use:   "trust [config-file]",
+	Short: "Trust the commands and host access defined in a config file",
+	Long: `Record the security sensitive settings (host commands, docker mount, docker options) of a config file as
+trusted. runcontainer asks for confirmation, or fails when not interactive, if these settings are new or changed.
+The shell completion only runs the profile image to list its executables if the config file is trusted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dockerConfigurationsFileName, dockerConfigurations, err := getTrustCommandConfigurations(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		store, err := loadTrustStore()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		store.Trust(dockerConfigurationsFileName, dockerConfigurations)
+		if err := store.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Trusted %s\n", dockerConfigurationsFileName)
+	},
+}
+
+// untrustCmd represents the untrust command
+var untrustCmd = &cobra.Command{
+	Use:   "untrust [config-file]",
+	Short: "Remove a config file from the trusted config files",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dockerConfigurationsFileName := cfgFile
+		if len(args) > 0 {
+			dockerConfigurationsFileName = args[0]
+		}
+		if dockerConfigurationsFileName == "" {
+			var err error
+			if dockerConfigurationsFileName, _, err = loadConfigurations(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		store, err := loadTrustStore()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if !store.Untrust(dockerConfigurationsFileName) {
+			fmt.Fprintf(os.Stderr, "%s was not trusted\n", dockerConfigurationsFileName)
+			return
+		}
+		if err := store.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Untrusted %s\n", dockerConfigurationsFileName)
+	},
+}
+
+func getTrustCommandConfigurations(args []string) (string, *runcontainer.DockerConfigs, error) {
+	if len(args) == 0 {
+		return loadConfigurations()
+	}
+	dockerConfigurations, err := readConfigurations(args[0])
+	return args[0], dockerConfigurations, err
+}
+
+func loadTrustStore() (*runcontainer.TrustStore, error) {
+	path, err := runcontainer.DefaultTrustStorePath()
+	if err != nil {
+		return nil, err
+	}
+	return runcontainer.LoadTrustStore(path)
+}
+
+// isTrusted returns true if the config file has been trusted by the user and did not change since, without asking.
+func isTrusted(dockerConfigurationsFileName string, dockerConfigurations *runcontainer.DockerConfigs) bool {
+	store, err := loadTrustStore()
+	return err == nil && store.IsTrusted(dockerConfigurationsFileName, dockerConfigurations)
+}
+
+// checkTrust ensures that the security sensitive settings of the config file have been approved by the user. The
+// user is asked to approve new or changed settings, this fails if the session is not interactive.
+func checkTrust(dockerConfigurationsFileName string, dockerConfigurations *runcontainer.DockerConfigs) error {
+	if !dockerConfigurations.RequiresTrust() {
+		return nil
+	}
+
+	store, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	if store.IsTrusted(dockerConfigurationsFileName, dockerConfigurations) {
+		logrus.Debugf("%s is trusted", dockerConfigurationsFileName)
+		return nil
+	}
+
+	if !runcontainer.IsTerminal(os.Stdin) {
+		return fmt.Errorf("%s is not trusted or its commands changed since it was trusted, review it and run 'runcontainer trust %s'", dockerConfigurationsFileName, dockerConfigurationsFileName)
+	}
+
+	settings, err := PrettyJson(dockerConfigurations.GetTrustedSettings())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s is new or changed and can run commands on this host or access it:\n%s\nDo you trust it? [y/N] ", dockerConfigurationsFileName, settings)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return fmt.Errorf("%s is not trusted", dockerConfigurationsFileName)
+	}
+
+	store.Trust(dockerConfigurationsFileName, dockerConfigurations)
+	return store.Save()
+}
+
+func init() {
+	rootCmd.AddCommand(trustCmd)
+	rootCmd.AddCommand(untrustCmd)
+
+	trustCmd.ValidArgsFunction = completeConfigFiles
+	untrustCmd.ValidArgsFunction = completeConfigFiles
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+	"github.com/taliesins/runcontainer/runcontainer"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var volumeRemoveForce bool
+
+// volumesCmd represents the volumes command
+var volumesCmd = &cobra.Command{
+	Use:   "volumes",
+	Short: "Manage the home and temp volumes of the containers",
+	Long: `The container homes (tgf-<username>) and the shared temp folder (tgf) are persisted in docker volumes, the
+tgf prefix can be changed with the volume-prefix of the profiles.
+Archive a volume to move it to another host, or remove it to reset a broken container home:
+	runcontainer volumes backup tgf-jdoe home.tar
+	runcontainer volumes rm tgf-jdoe
+The volumes are managed on the docker daemon configured by the environment, or on the daemon of the profile
+(docker-host, docker-context...) if --profile is given.`,
+}
+
+var volumesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the volumes",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		volumes, err := mustNewVolumeRunner().ListVolumes(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tSIZE\tLAST USED")
+		for _, volume := range volumes {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", volume.Name, formatVolumeSize(volume.Size), formatLastUse(volume.LastUsed))
+		}
+		writer.Flush()
+	},
+}
+
+var volumesInspectCmd = &cobra.Command{
+	Use:               "inspect <volume>",
+	Short:             "Show the details of a volume",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeVolumes,
+	Run: func(cmd *cobra.Command, args []string) {
+		volume, err := mustNewVolumeRunner().InspectVolume(context.Background(), args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		containers := "unknown"
+		if volume.Containers >= 0 {
+			containers = fmt.Sprint(volume.Containers)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(writer, "Name:\t%s\n", volume.Name)
+		fmt.Fprintf(writer, "Driver:\t%s\n", volume.Driver)
+		fmt.Fprintf(writer, "Mountpoint:\t%s\n", volume.Mountpoint)
+		fmt.Fprintf(writer, "Created:\t%s\n", formatLastUse(volume.Created))
+		fmt.Fprintf(writer, "Size:\t%s\n", formatVolumeSize(volume.Size))
+		fmt.Fprintf(writer, "Containers:\t%s\n", containers)
+		fmt.Fprintf(writer, "Last used:\t%s\n", formatLastUse(volume.LastUsed))
+		writer.Flush()
+	},
+}
+
+var volumesRemoveCmd = &cobra.Command{
+	Use:               "rm <volume>...",
+	Short:             "Remove volumes",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeVolumes,
+	Run: func(cmd *cobra.Command, args []string) {
+		runner := mustNewVolumeRunner()
+		for _, name := range args {
+			if err := runner.RemoveVolume(context.Background(), name, volumeRemoveForce); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Removed %s\n", name)
+		}
+	},
+}
+
+var volumesBackupCmd = &cobra.Command{
+	Use:               "backup <volume> <archive>",
+	Short:             "Archive the content of a volume in a tar file (- for stdout)",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeVolumes,
+	Run: func(cmd *cobra.Command, args []string) {
+		var archive io.Writer = os.Stdout
+		if args[1] != "-" {
+			file, err := os.Create(args[1])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			archive = file
+		}
+
+		if err := mustNewVolumeRunner().BackupVolume(context.Background(), args[0], archive); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if args[1] != "-" {
+				os.Remove(args[1])
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+var volumesRestoreCmd = &cobra.Command{
+	Use:   "restore <volume> <archive>",
+	Short: "Replace the content of a volume by the content of a tar file (- for stdin)",
+	Long: `Replace the content of a volume by the content of a tar file (- for stdin). The volume is created if it does
+not exist. Its current content is deleted.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeVolumes,
+	Run: func(cmd *cobra.Command, args []string) {
+		var archive io.Reader = os.Stdin
+		if args[1] != "-" {
+			file, err := os.Open(args[1])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			archive = file
+		}
+
+		if err := mustNewVolumeRunner().RestoreVolume(context.Background(), args[0], archive); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// newVolumeRunner returns a runner managing the volumes with the prefixes of the configuration file, if any. The
+// volumes of the docker daemon of the profile are managed if --profile is given, otherwise the volumes of the daemon
+// configured by the environment.
+func newVolumeRunner() (*runcontainer.Runner, error) {
+	dockerConfigurationsFileName, dockerConfigurations, err := loadConfigurations()
+	if err != nil {
+		if cfgProfile != "" {
+			return nil, err
+		}
+		return runcontainer.NewRunner(), nil
+	}
+
+	options := []runcontainer.Option{runcontainer.WithVolumePrefixes(dockerConfigurations.VolumePrefixes()...)}
+	if cfgProfile != "" {
+		// The daemon of the profile is a trusted setting
+		if err := checkTrust(dockerConfigurationsFileName, dockerConfigurations); err != nil {
+			return nil, err
+		}
+		dockerConfiguration, err := resolveProfile(dockerConfigurationsFileName, dockerConfigurations, cfgProfile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, runcontainer.WithVolumeProfile(dockerConfiguration))
+	}
+	return runcontainer.NewRunner(options...), nil
+}
+
+// mustNewVolumeRunner returns the runner managing the volumes, it exits on error.
+func mustNewVolumeRunner() *runcontainer.Runner {
+	runner, err := newVolumeRunner()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return runner
+}
+
+func formatVolumeSize(size int64) string {
+	if size < 0 {
+		return "unknown"
+	}
+	return units.HumanSize(float64(size))
+}
+
+func formatLastUse(lastUse time.Time) string {
+	if lastUse.IsZero() {
+		return "unknown"
+	}
+	return lastUse.Local().Format("2006-01-02 15:04:05")
+}
+
+// completeVolumes completes the first argument with the volume names.
+func completeVolumes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 && cmd.Name() != "rm" {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	if cfgProfile != "" {
+		// The completion cannot ask for trust, the daemon of a profile that is not trusted is not used
+		dockerConfigurationsFileName, dockerConfigurations, err := loadCompletionConfigurations()
+		if err != nil || dockerConfigurations.RequiresTrust() && !isTrusted(dockerConfigurationsFileName, dockerConfigurations) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	runner, err := newVolumeRunner()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	volumes, err := runner.ListVolumes(context.Background())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, volume := range volumes {
+		names = append(names, volume.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	rootCmd.AddCommand(volumesCmd)
+	volumesCmd.AddCommand(volumesListCmd, volumesInspectCmd, volumesRemoveCmd, volumesBackupCmd, volumesRestoreCmd)
+
+	volumesRemoveCmd.Flags().BoolVarP(&volumeRemoveForce, "force", "f", false, "remove the volume even if it is used by a container")
+}
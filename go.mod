@@ -7,9 +7,12 @@ require (
 	github.com/containerd/containerd v1.5.2 // indirect
 	github.com/coveooss/gotemplate/v3 v3.7.0
 	github.com/docker/docker v20.10.7+incompatible
-	github.com/docker/go-connections v0.4.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
+	github.com/docker/go-connections v0.4.0
+	github.com/docker/go-units v0.4.0
+	github.com/mattn/go-isatty v0.0.12
+	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.2.0
 	github.com/spf13/viper v1.8.1
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,211 @@
+package runcontainer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAuditMaxEntries = 10000
+
+// AuditConfig sets the retention of the audit log. The oldest entries are removed when the log has more than
+// MaxEntries entries or when they are older than MaxAge (i.e. 720h).
+type AuditConfig struct {
+	MaxEntries int    `yaml:"max-entries,omitempty" json:"max-entries,omitempty" hcl:"max-entries,omitempty"`
+	MaxAge     string `yaml:"max-age,omitempty" json:"max-age,omitempty" hcl:"max-age,omitempty"`
+}
+
+// AuditEntry is the record of a run in the audit log.
+type AuditEntry struct {
+	Time             time.Time `json:"time"`
+	User             string    `json:"user"`
+	Profile          string    `json:"profile,omitempty"`
+	ConfigFile       string    `json:"config-file,omitempty"`
+	Image            string    `json:"image"`
+	ImageDigest      string    `json:"image-digest,omitempty"`
+	Args             []string  `json:"args,omitempty"`
+	WorkingDirectory string    `json:"working-directory"`
+	ExitCode         int       `json:"exit-code"`
+	Duration         float64   `json:"duration-seconds"`
+}
+
+// AuditLog is a JSON lines file recording the runs. It can be shared by several processes.
+type AuditLog struct {
+	path       string
+	maxEntries int
+	maxAge     time.Duration
+	lock       sync.Mutex
+}
+
+// DefaultAuditLogPath returns the path of the audit log in the user configuration folder.
+func DefaultAuditLogPath() (string, error) {
+	folder, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(folder, "runcontainer", "audit.jsonl"), nil
+}
+
+// NewAuditLog returns the audit log stored in path with the retention of the configuration (which may be nil).
+func NewAuditLog(path string, config *AuditConfig) (*AuditLog, error) {
+	log := &AuditLog{path: path, maxEntries: defaultAuditMaxEntries}
+	if config != nil {
+		if config.MaxEntries > 0 {
+			log.maxEntries = config.MaxEntries
+		}
+		maxAge, err := parseDuration(config.MaxAge, 0)
+		if err != nil {
+			return nil, err
+		}
+		log.maxAge = maxAge
+	}
+	return log, nil
+}
+
+// Append adds an entry to the log after redacting its secrets and applies the retention.
+func (log *AuditLog) Append(entry AuditEntry) error {
+	entry.Args = redactArgs(entry.Args)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(log.path), 0700); err != nil {
+		return err
+	}
+	// The log is replaced when it is trimmed, the lock shared with the other processes is kept in a separate file
+	lock, err := os.OpenFile(log.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if _, err := lockFile(lock, true); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(log.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return log.applyRetention()
+}
+
+// Entries returns the entries of the log, oldest first.
+func (log *AuditLog) Entries() ([]AuditEntry, error) {
+	content, err := ioutil.ReadFile(log.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		// A truncated line (i.e. interrupted write) is ignored rather than making the whole log unreadable
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (log *AuditLog) applyRetention() error {
+	entries, err := log.Entries()
+	if err != nil {
+		return err
+	}
+
+	keep := entries
+	if log.maxAge > 0 {
+		limit := time.Now().Add(-log.maxAge)
+		for len(keep) > 0 && keep[0].Time.Before(limit) {
+			keep = keep[1:]
+		}
+	}
+	if len(keep) > log.maxEntries {
+		keep = keep[len(keep)-log.maxEntries:]
+	}
+	if len(keep) == len(entries) {
+		return nil
+	}
+
+	var content bytes.Buffer
+	for _, entry := range keep {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		content.Write(append(line, '\n'))
+	}
+	temp := log.path + ".tmp"
+	if err := ioutil.WriteFile(temp, content.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, log.path)
+}
+
+var reSecretName = regexp.MustCompile(`(?i)(password|passwd|secret|token|api[-_]?key|access[-_]?key|private[-_]?key|credential)`)
+var reURLCredentials = regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`)
+
+const redacted = "***"
+
+// redactArgs replaces the values of the arguments that look like secrets: name=value, --option=name=value and
+// --name value where the name mentions a password, token, key... and the passwords of URLs.
+func redactArgs(args []string) []string {
+	result := make([]string, 0, len(args))
+	redactNext := false
+	for _, arg := range args {
+		index := getSecretValueIndex(arg)
+		switch {
+		case redactNext:
+			arg = redacted
+			redactNext = false
+		case index > 0:
+			arg = arg[:index+1] + redacted
+		case !strings.Contains(arg, "=") && strings.HasPrefix(arg, "-") && reSecretName.MatchString(arg):
+			// The value of the option is the next argument
+			redactNext = true
+		default:
+			arg = reURLCredentials.ReplaceAllString(arg, "${1}"+redacted+"@")
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// getSecretValueIndex returns the index of the = preceding the value of a secret in an argument, or -1. The names
+// before each = are checked so the value of an option is also found (e.g. -var=password=value).
+func getSecretValueIndex(arg string) int {
+	for index := strings.Index(arg, "="); index > 0; {
+		if reSecretName.MatchString(arg[:index]) {
+			return index
+		}
+		next := strings.Index(arg[index+1:], "=")
+		if next < 0 {
+			break
+		}
+		index += next + 1
+	}
+	return -1
+}
@@ -0,0 +1,99 @@
+package runcontainer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CacheConfig is a folder of the container persisted in a named volume (i.e. a package cache). In the configuration
+// file, a cache is either the name of a preset (i.e. "pip") or an object. The target and the environment variable of a
+// preset can be overridden.
+type CacheConfig struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty" hcl:"name,omitempty"`
+	// Target is the folder of the container where the volume is mounted
+	Target string `yaml:"target,omitempty" json:"target,omitempty" hcl:"target,omitempty"`
+	// Env is the environment variable set to the target so the tool uses the cache (i.e. PIP_CACHE_DIR)
+	Env string `yaml:"env,omitempty" json:"env,omitempty" hcl:"env,omitempty"`
+	// PerImage uses a distinct volume for every image, for caches that are not compatible between images
+	PerImage bool `yaml:"per-image,omitempty" json:"per-image,omitempty" hcl:"per-image,omitempty"`
+}
+
+// cachePresets are the caches that can be referred by their name
+var cachePresets = map[string]CacheConfig{
+	"terraform-plugins": {Target: "/var/cache/runcontainer/terraform-plugins", Env: "TF_PLUGIN_CACHE_DIR"},
+	"pip":               {Target: "/var/cache/runcontainer/pip", Env: "PIP_CACHE_DIR"},
+	"go":                {Target: "/var/cache/runcontainer/go", Env: "GOMODCACHE"},
+	"npm":               {Target: "/var/cache/runcontainer/npm", Env: "npm_config_cache"},
+	"helm":              {Target: "/var/cache/runcontainer/helm", Env: "HELM_CACHE_HOME"},
+}
+
+// UnmarshalJSON accepts the name of a preset or a cache object.
+func (cache *CacheConfig) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*cache = CacheConfig{Name: name}
+		return nil
+	}
+	type plainCacheConfig CacheConfig
+	return json.Unmarshal(data, (*plainCacheConfig)(cache))
+}
+
+// resolve returns the cache with the values of its preset applied.
+func (cache CacheConfig) resolve() (CacheConfig, error) {
+	if !reVolumePrefix.MatchString(cache.Name) {
+		return cache, fmt.Errorf("invalid cache name '%s', only letters, digits, _, . and - are allowed", cache.Name)
+	}
+	if preset, isPreset := cachePresets[cache.Name]; isPreset {
+		if cache.Target == "" {
+			cache.Target = preset.Target
+		}
+		if cache.Env == "" {
+			cache.Env = preset.Env
+		}
+	}
+	if cache.Target == "" {
+		return cache, fmt.Errorf("cache %s has no target and is not one of the presets (%s)", cache.Name, strings.Join(getCachePresetNames(), ", "))
+	}
+	return cache, nil
+}
+
+// getVolumeName returns the volume of the cache, shared by all the profiles using the same prefix.
+func (cache CacheConfig) getVolumeName(prefix, image string) string {
+	name := fmt.Sprintf("%s-cache-%s", prefix, cache.Name)
+	if cache.PerImage {
+		hash := sha256.Sum256([]byte(image))
+		name = fmt.Sprintf("%s-%x", name, hash[:6])
+	}
+	return name
+}
+
+// createCacheVolume creates the volume of a cache if it does not exist yet. A new volume is owned by root, so it is
+// given to the owner (uid:gid), if any, for the cache to be writable when the container is run as the current user.
+func (runner *Runner) createCacheVolume(ctx context.Context, executor Executor, cache CacheConfig, volume, owner string) error {
+	if _, err := executeDocker(ctx, executor, "volume", "inspect", volume); err == nil {
+		return nil
+	}
+	runner.logger.Debugf("Creating volume %s for cache %s", volume, cache.Name)
+	if _, err := executeDocker(ctx, executor, "volume", "create", "--label", "runcontainer.cache="+cache.Name, volume); err != nil {
+		return err
+	}
+	if owner != "" {
+		if _, err := executeDocker(ctx, executor, "run", "--rm", "-v", volume+":/cache", volumeHelperImage, "chown", owner, "/cache"); err != nil {
+			return fmt.Errorf("unable to give cache %s to %s: %v", cache.Name, owner, err)
+		}
+	}
+	return nil
+}
+
+func getCachePresetNames() []string {
+	names := make([]string, 0, len(cachePresets))
+	for name := range cachePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,47 @@
+package runcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCacheConfigUnmarshal(t *testing.T) {
+	var config DockerConfig
+	content := `{"caches": ["pip", {"name": "go", "per-image": true}, {"name": "gradle", "target": "/home/gradle/.gradle/caches"}]}`
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CacheConfig{{Name: "pip"}, {Name: "go", PerImage: true}, {Name: "gradle", Target: "/home/gradle/.gradle/caches"}}
+	if !reflect.DeepEqual(config.Caches, want) {
+		t.Errorf("caches = %+v, want %+v", config.Caches, want)
+	}
+
+	if _, err := (CacheConfig{Name: "gradle"}).resolve(); err == nil {
+		t.Error("a custom cache without target should be rejected")
+	}
+}
+
+func TestRunnerCaches(t *testing.T) {
+	run := newTestRun(t)
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		// Only the pip cache exists
+		if command.String() == "docker volume inspect tgf-cache-terraform-plugins" {
+			return &ExitError{Code: 1}
+		}
+		return nil
+	}
+
+	profile := run.profile()
+	profile.WithCurrentUser = true
+	profile.TempDirMountLocation = MountLocNone
+	profile.Environment["PIP_CACHE_DIR"] = "/pip"
+	profile.Caches = []CacheConfig{{Name: "terraform-plugins"}, {Name: "pip", PerImage: true}}
+	if _, err := run.runner.Run(context.Background(), profile, []string{"terraform", "init"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "caches", run.commandLines(t))
+}
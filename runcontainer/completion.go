@@ -0,0 +1,91 @@
+package runcontainer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listExecutablesScript prints the name of the executable files found in the container PATH.
+const listExecutablesScript = `IFS=:; for d in $PATH; do for f in "$d"/*; do [ -f "$f" ] && [ -x "$f" ] && echo "${f##*/}"; done; done`
+
+// CompleteProfiles returns the sorted names of the profiles starting with prefix.
+func (configs *DockerConfigs) CompleteProfiles(prefix string) []string {
+	profiles := make([]string, 0, len(configs.Configs))
+	for name := range configs.Configs {
+		if strings.HasPrefix(name, prefix) {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// CompleteTasks returns the sorted names of the tasks starting with prefix, each followed by a tab and the task
+// description as expected by the shell completions.
+func (config *DockerConfig) CompleteTasks(prefix string) []string {
+	var result []string
+	for _, name := range config.GetTaskNames() {
+		if task := config.Tasks[name]; task != nil && strings.HasPrefix(name, prefix) {
+			result = append(result, fmt.Sprintf("%s\t%s", name, task.Description))
+		}
+	}
+	return result
+}
+
+// GetImageExecutables returns the sorted names of the executables available in the PATH of the profile image. The
+// list is cached per image ID, nothing is returned if the image has not been pulled. The image is only run to build
+// the list if runImage is set, otherwise only a cached list is returned.
+func (config *DockerConfig) GetImageExecutables(runImage bool) ([]string, error) {
+	endpoint, err := config.getDockerEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := endpoint.getSharedClient()
+	if err != nil {
+		return nil, err
+	}
+	imageSummary, err := findImage(context.Background(), cli, config.GetImageName())
+	if err != nil || imageSummary == nil {
+		return nil, err
+	}
+
+	cacheFile := ""
+	if cacheFolder, err := os.UserCacheDir(); err == nil {
+		cacheFile = filepath.Join(cacheFolder, "runcontainer", "executables", strings.TrimPrefix(imageSummary.ID, "sha256:"))
+		if content, err := ioutil.ReadFile(cacheFile); err == nil {
+			return strings.Fields(string(content)), nil
+		}
+	}
+	if !runImage {
+		return nil, nil
+	}
+
+	executor := dockerExecutor{Executor: execExecutor{}, options: endpoint.getCLIOptions()}
+	output, err := executeDocker(context.Background(), executor, "run", "--rm", "--entrypoint", "/bin/sh", imageSummary.ID, "-c", listExecutablesScript)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the executables of %s: %v", config.GetImageName(), err)
+	}
+
+	unique := map[string]bool{}
+	for _, name := range strings.Fields(output) {
+		unique[name] = true
+	}
+	executables := make([]string, 0, len(unique))
+	for name := range unique {
+		executables = append(executables, name)
+	}
+	sort.Strings(executables)
+
+	if cacheFile != "" {
+		// The cache is an optimization, failing to write it is not an error
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err == nil {
+			ioutil.WriteFile(cacheFile, []byte(strings.Join(executables, "\n")), 0644)
+		}
+	}
+	return executables, nil
+}
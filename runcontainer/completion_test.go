@@ -0,0 +1,59 @@
+package runcontainer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteProfiles(t *testing.T) {
+	configs := &DockerConfigs{Configs: map[string]*DockerConfig{
+		"default":    {},
+		"terraform":  {},
+		"terragrunt": {},
+		"python":     {},
+	}}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"default", "python", "terraform", "terragrunt"}},
+		{"terra", []string{"terraform", "terragrunt"}},
+		{"terragrunt", []string{"terragrunt"}},
+		{"go", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if profiles := configs.CompleteProfiles(tt.prefix); !reflect.DeepEqual(profiles, tt.want) {
+				t.Errorf("profiles = %q, want %q", profiles, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteTasks(t *testing.T) {
+	config := &DockerConfig{Tasks: map[string]*Task{
+		"plan":   {Command: "terraform plan", Description: "Show the changes"},
+		"apply":  {Command: "terraform apply", Description: "Apply the changes"},
+		"print":  {Command: "terraform show"},
+		"broken": nil,
+	}}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"apply\tApply the changes", "plan\tShow the changes", "print\t"}},
+		{"p", []string{"plan\tShow the changes", "print\t"}},
+		{"apply", []string{"apply\tApply the changes"}},
+		{"b", nil},
+		{"destroy", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if tasks := config.CompleteTasks(tt.prefix); !reflect.DeepEqual(tasks, tt.want) {
+				t.Errorf("tasks = %q, want %q", tasks, tt.want)
+			}
+		})
+	}
+}
@@ -5,18 +5,21 @@ import (
 	"context"
 	"fmt"
 	"github.com/blang/semver"
-	"github.com/coveooss/gotemplate/v3/utils"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"os/exec"
-	"os/user"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 )
 
@@ -31,8 +34,9 @@ const (
 // Version is initialized at build time through -ldflags "-X main.Version=<version number>"
 var version = "(Locally Built)"
 
-var dockerClient *client.Client
+var dockerClient client.APIClient
 var dockerContext context.Context
+var dockerClientLock sync.Mutex
 
 // MountLocation is a docker mount location
 type MountLocation string
@@ -47,6 +51,9 @@ const (
 type DockerConfigs struct {
 	DefaultProfile string 			`yaml:"default-profile,omitempty" json:"default-profile,omitempty" hcl:"default-profile,omitempty"`
 	Configs map[string]*DockerConfig `yaml:"configs,omitempty" json:"configs,omitempty" hcl:"configs,omitempty"`
+	// Audit sets the retention of the audit log recording the runs, it is only read from the config file of the home
+	// directory
+	Audit *AuditConfig `yaml:"audit,omitempty" json:"audit,omitempty" hcl:"audit,omitempty"`
 }
 
 type DockerConfig struct{
@@ -63,6 +70,55 @@ type DockerConfig struct{
 	Environment          map[string]string `yaml:"environment,omitempty" json:"environment,omitempty" hcl:"environment,omitempty"`
 	RunBeforeCommands    []string          `yaml:"run-before-commands,omitempty" json:"run-before-commands,omitempty" hcl:"run-before-commands,omitempty"`
 	RunAfterCommands     []string          `yaml:"run-after-commands,omitempty" json:"run-after-commands,omitempty" hcl:"run-after-commands,omitempty"`
+	Hooks                *Hooks            `yaml:"hooks,omitempty" json:"hooks,omitempty" hcl:"hooks,omitempty"`
+	Services             map[string]*ServiceConfig `yaml:"services,omitempty" json:"services,omitempty" hcl:"services,omitempty"`
+	Tasks                map[string]*Task  `yaml:"tasks,omitempty" json:"tasks,omitempty" hcl:"tasks,omitempty"`
+	Matrix               *MatrixConfig     `yaml:"matrix,omitempty" json:"matrix,omitempty" hcl:"matrix,omitempty"`
+
+	// MirrorHostPath mounts the current directory at its host path instead of /<MountPoint> so absolute paths are
+	// identical inside and outside the container (and in sibling containers started through the docker mount).
+	MirrorHostPath bool `yaml:"mirror-host-path,omitempty" json:"mirror-host-path,omitempty" hcl:"mirror-host-path,omitempty"`
+	// MirrorTempPath mounts the host temp cache at its host path instead of /var/runcontainer.
+	MirrorTempPath bool `yaml:"mirror-temp-path,omitempty" json:"mirror-temp-path,omitempty" hcl:"mirror-temp-path,omitempty"`
+	// RewriteOutputPaths replaces the container mount paths by the host paths in the container output so editors can
+	// open the files it mentions. Terminal resizes are not forwarded to the container when this is enabled.
+	RewriteOutputPaths bool `yaml:"rewrite-output-paths,omitempty" json:"rewrite-output-paths,omitempty" hcl:"rewrite-output-paths,omitempty"`
+	// TranslateArgs replaces the arguments referring to host files in a mounted folder (e.g. ./modules/vpc) by their
+	// path inside the container. Prefix an argument with \ to pass it unchanged.
+	TranslateArgs bool `yaml:"translate-args,omitempty" json:"translate-args,omitempty" hcl:"translate-args,omitempty"`
+	// Timeout is the maximum duration of the run (e.g. 30m), including the run-before-commands and run-after-commands.
+	// The container is stopped when it expires and the run exits with TimeoutExitCode.
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty" hcl:"timeout,omitempty"`
+	// StopSignal is the signal sent to the container to stop it (default is the image STOPSIGNAL or SIGTERM).
+	StopSignal string `yaml:"stop-signal,omitempty" json:"stop-signal,omitempty" hcl:"stop-signal,omitempty"`
+	// StopGracePeriod is the time given to the container to exit after the stop signal before it is killed (default
+	// is 10s). The failure hooks of a run that timed out are given the same time to complete.
+	StopGracePeriod string `yaml:"stop-grace-period,omitempty" json:"stop-grace-period,omitempty" hcl:"stop-grace-period,omitempty"`
+	// Lock serializes the runs sharing the workspace root (workspace) or the workspace root and the profile (profile)
+	// with an advisory file lock. Runs are not locked by default (none).
+	Lock LockMode `yaml:"lock,omitempty" json:"lock,omitempty" hcl:"lock,omitempty"`
+	// HomeVolumeScope defines which runs share the home volume: all the runs of the user (shared, default), the runs of
+	// the profile, of the project (configuration file) or of the image.
+	HomeVolumeScope VolumeScope `yaml:"home-volume-scope,omitempty" json:"home-volume-scope,omitempty" hcl:"home-volume-scope,omitempty"`
+	// VolumePrefix is the name of the temp volume and the prefix of the home volumes (default is tgf).
+	VolumePrefix string `yaml:"volume-prefix,omitempty" json:"volume-prefix,omitempty" hcl:"volume-prefix,omitempty"`
+	// Caches are folders of the container persisted in volumes shared by the runs (i.e. the terraform plugins cache).
+	Caches []CacheConfig `yaml:"caches,omitempty" json:"caches,omitempty" hcl:"caches,omitempty"`
+	// DockerHost is the daemon running the containers of the profile (unix://, tcp:// or ssh://), DockerContext is the
+	// docker context selecting it instead. The daemon of the environment is used by default.
+	DockerHost    string `yaml:"docker-host,omitempty" json:"docker-host,omitempty" hcl:"docker-host,omitempty"`
+	DockerContext string `yaml:"docker-context,omitempty" json:"docker-context,omitempty" hcl:"docker-context,omitempty"`
+	// DockerCertPath is the folder containing the TLS material (ca.pem, cert.pem and key.pem) of a tcp:// docker-host,
+	// the certificate of the daemon is verified if DockerTLSVerify is set.
+	DockerCertPath  string `yaml:"docker-cert-path,omitempty" json:"docker-cert-path,omitempty" hcl:"docker-cert-path,omitempty"`
+	DockerTLSVerify bool   `yaml:"docker-tls-verify,omitempty" json:"docker-tls-verify,omitempty" hcl:"docker-tls-verify,omitempty"`
+	// WorkspaceTransfer copies the workspace into the container and back (copy or sync) instead of mounting it (bind,
+	// default), for daemons that cannot access the host folders. The workspace is the current directory, the
+	// files matching the patterns of its .runcontainerignore file are not copied.
+	WorkspaceTransfer TransferMode `yaml:"workspace-transfer,omitempty" json:"workspace-transfer,omitempty" hcl:"workspace-transfer,omitempty"`
+	// Security hardens the container (read-only root filesystem, capabilities, seccomp...), either with the strict
+	// preset or with individual settings.
+	Security *SecurityConfig `yaml:"security,omitempty" json:"security,omitempty" hcl:"security,omitempty"`
 }
 
 func (config *DockerConfig) GetImageName() string {
@@ -76,165 +132,41 @@ func (config *DockerConfig) GetImageName() string {
 	return config.Image
 }
 
-func (config *DockerConfig) Execute() int {
-	cwd, err := getCwd()
-	if err != nil {
-		panic(err)
-	}
+// Streams are the standard streams used by the container and the hooks.
+type Streams struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
 
-	currentDrive := fmt.Sprintf("%s/", filepath.VolumeName(cwd))
-	rootFolder := strings.Split(strings.TrimPrefix(cwd, currentDrive), "/")[0]
-	sourceFolder := fmt.Sprintf("/%s", filepath.ToSlash(strings.Replace(strings.TrimPrefix(cwd, currentDrive), rootFolder, config.MountPoint, 1)))
+// StandardStreams returns the streams of the current process.
+func StandardStreams() Streams {
+	return Streams{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}
+}
 
-	imageName := config.GetImageName()
+// Execute runs the container and returns its exit code. The args, if any, replace the configured entry point.
+func (config *DockerConfig) Execute(args ...string) int {
+	return config.ExecuteWithOptions(args)
+}
 
-	dockerArgs := []string{
-		"run",
-	}
-	if config.DockerInteractive {
-		dockerArgs = append(dockerArgs, "-it")
-	}
-	dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s%s:/%s", convertDrive(currentDrive), rootFolder, config.MountPoint), "-w", sourceFolder)
+// ExecuteWithStreams runs the container using the supplied streams and returns its exit code.
+func (config *DockerConfig) ExecuteWithStreams(streams Streams, args ...string) int {
+	return config.ExecuteWithOptions(args, WithStreams(streams))
+}
 
-	if config.WithDockerMount {
-		withDockerMountArgs := getDockerMountArgs()
-		dockerArgs = append(dockerArgs, withDockerMountArgs...)
-	}
+// ExecuteWithOptions runs the container with a runner configured by the options and returns its exit code. Unlike
+// Runner.Run, it handles the interruptions of the current process to stop the container and its services.
+func (config *DockerConfig) ExecuteWithOptions(args []string, options ...Option) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	currentUser, err := user.Current()
+	runner := NewRunner(options...)
+	result, err := runner.Run(ctx, config, args)
 	if err != nil {
-		panic(err)
-	}
-
-	// No need to map to current user on windows. Files written by docker containers in windows seem to be accessible by the user calling docker
-	if config.WithCurrentUser && runtime.GOOS != "windows" {
-		dockerArgs = append(dockerArgs, fmt.Sprintf("--user=%s:%s", currentUser.Uid, currentUser.Gid))
-	}
-
-	if config.MountHomeDirectory {
-		home := filepath.ToSlash(currentUser.HomeDir)
-		mountingHome := fmt.Sprintf("/home/%s", filepath.Base(home))
-
-		dockerArgs = append(dockerArgs, []string{
-			"-v", fmt.Sprintf("%v:%v", convertDrive(home), mountingHome),
-			"-e", fmt.Sprintf("HOME=%v", mountingHome),
-		}...)
-	} else if config.TempDirMountLocation != MountLocNone {
-		// If temp location is not disabled, we persist the home folder in a docker volume
-		imageSummary, err := getImageSummary(imageName)
-		if err != nil {
-			panic(err)
-		}
-
-		image, err := inspectImage(imageSummary.ID)
-		if err != nil {
-			panic(err)
-		}
-
-		username := currentUser.Username
-
-		if image.Config.User != "" {
-			// If an explicit user is defined in the image, we use that user instead of the actual one
-			// This ensure to not mount a folder with no permission to write into it
-			username = image.Config.User
-		}
-
-		// Fix for Windows containing the domain name in the Username (e.g. ACME\jsmith)
-		// The backslash is not accepted for a Docker volume path
-		splitUsername := strings.Split(username, "\\")
-		username = splitUsername[len(splitUsername) - 1]
-
-		homePath := fmt.Sprintf("/home/%s", username)
-		dockerArgs = append(dockerArgs,
-			"-e", fmt.Sprintf("HOME=%s", homePath),
-			"-v", fmt.Sprintf("%s-%s:%s", dockerVolumeName, username, homePath),
-		)
-	}
-
-	dockerArgs = append(dockerArgs, config.DockerOptions...)
-
-	switch config.TempDirMountLocation {
-	case MountLocHost:
-		tempDir, err := filepath.EvalSymlinks(os.TempDir())
-		if err != nil {
-			panic(err)
-		}
-
-		temp := filepath.ToSlash(filepath.Join(tempDir, "runcontainer-cache"))
-		tempDrive := fmt.Sprintf("%s/", filepath.VolumeName(temp))
-		tempFolder := strings.TrimPrefix(temp, tempDrive)
-		if runtime.GOOS == "windows" {
-			os.Mkdir(temp, 0755)
-		}
-		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s%s:%s", convertDrive(tempDrive), tempFolder, dockerMountImagePath))
-		config.Environment["RUNCONTAINER_TEMP_FOLDER"] = path.Join(tempDrive, tempFolder)
-	case MountLocNone:
-		// Nothing to do
-	case MountLocVolume:
-		// docker's -v option will automatically create the volume if it doesn't already exist
-		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s", dockerVolumeName, dockerMountImagePath))
-	default:
-		// We added a mount location and forgot to handle it...
-		panic(fmt.Sprintf("Unknown mount location '%s'.  Please report a bug.", config.TempDirMountLocation))
-	}
-
-	config.Environment["RUNCONTAINER_COMMAND"] = config.EntryPoint
-	config.Environment["RUNCONTAINER_VERSION"] = version
-	config.Environment["RUNCONTAINER_ARGS"] = strings.Join(os.Args, " ")
-	config.Environment["RUNCONTAINER_LAUNCH_FOLDER"] = sourceFolder
-	config.Environment["RUNCONTAINER_IMAGE_NAME"] = imageName // sha256 of image
-	config.Environment["RUNCONTAINER_IMAGE"] = config.Image
-	if config.ImageTag != "" {
-		config.Environment["RUNCONTAINER_IMAGE_TAG"] = config.ImageTag
-	}
-
-	if len(config.Environment) > 0 {
-		for key, val := range config.Environment {
-			os.Setenv(key, val)
-		}
-	}
-
-	for _, do := range config.DockerOptions {
-		dockerArgs = append(dockerArgs, strings.Split(do, " ")...)
-	}
-
-	if !listContainsElement(dockerArgs, "--name") {
-		// We do not remove the image after execution if a name has been provided
-		dockerArgs = append(dockerArgs, "--rm")
-	}
-
-	command := append(strings.Split(config.EntryPoint, " "))
-
-	dockerArgs = append(dockerArgs, getEnviron(config.MountHomeDirectory)...)
-	dockerArgs = append(dockerArgs, imageName)
-	dockerArgs = append(dockerArgs, command...)
-
-	dockerCmd := exec.Command("docker", dockerArgs...)
-	dockerCmd.Stdin = os.Stdin
-	dockerCmd.Stdout = os.Stdout
-
-	var stderr bytes.Buffer
-	dockerCmd.Stderr = &stderr
-
-	if err := runCommands(config.RunBeforeCommands); err != nil {
-		os.Stderr.WriteString(fmt.Sprintf("run before command failed: %v\r\n%v", config.RunBeforeCommands, err))
+		fmt.Fprintln(runner.streams.Err, err)
 		return 1
 	}
-	if err := dockerCmd.Run(); err != nil {
-		if stderr.Len() > 0 {
-			os.Stderr.WriteString(fmt.Sprintf("%s\n%s %s", stderr.String(), dockerCmd.Args[0], strings.Join(dockerArgs, " ")))
-			if runtime.GOOS == "windows" {
-				os.Stderr.WriteString(windowsMessage)
-			}
-			return 1
-		}
-	}
-	if err := runCommands(config.RunAfterCommands); err != nil {
-		os.Stderr.WriteString(fmt.Sprintf("run after command failed: %v\r\n%v", config.RunBeforeCommands, err))
-		return 1
-	}
-
-	return dockerCmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+	return result.ExitCode
 }
 
 var windowsMessage = `
@@ -252,15 +184,48 @@ to the drive letter:
 	Z:\ ==> /Z
 `
 
+// getSourceMount returns the host folder mounted into the container (root, the current directory if it is mirrored
+// or copied, or by default its top level folder), the path where it is mounted and the path of the current directory
+// inside the container.
+func (config *DockerConfig) getSourceMount(cwd, root string) (hostFolder, mountFolder, sourceFolder string, err error) {
+	currentDrive := fmt.Sprintf("%s/", filepath.VolumeName(cwd))
+	hostFolder = currentDrive + strings.Split(strings.TrimPrefix(cwd, currentDrive), "/")[0]
+
+	if root != "" {
+		hostFolder = root
+	} else if copied, _ := config.isWorkspaceCopied(); copied || config.MirrorHostPath {
+		// Only the current directory is copied or mirrored, its top level folder (e.g. /home) would expose much more
+		// of the host at its real path
+		hostFolder = cwd
+	}
+	relativeFolder := strings.TrimPrefix(cwd, hostFolder)
+
+	if config.MirrorHostPath {
+		return hostFolder, getMirrorPath(hostFolder), getMirrorPath(cwd), nil
+	}
+
+	mountFolder = fmt.Sprintf("/%s", config.MountPoint)
+	return hostFolder, mountFolder, path.Join(mountFolder, relativeFolder), nil
+}
+
+// getMirrorPath returns the path used inside the container to mirror a host path.
+// Linux containers have no drive letters, so on Windows C:/src is mirrored as /c/src.
+func getMirrorPath(hostPath string) string {
+	if volume := filepath.VolumeName(hostPath); runtime.GOOS == "windows" && len(volume) == 2 {
+		return fmt.Sprintf("/%s%s", strings.ToLower(volume[:1]), strings.TrimPrefix(hostPath, volume))
+	}
+	return hostPath
+}
+
 func getCwd() (string, error){
 	cwd, err := os.Getwd()
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	cwd, err = filepath.EvalSymlinks(cwd)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	cwd = filepath.ToSlash(cwd)
@@ -268,10 +233,12 @@ func getCwd() (string, error){
 	return cwd, nil
 }
 
-func getDockerClient() (*client.Client, context.Context, error) {
+// getDockerClient returns the client shared by the package level functions, the runners have their own client.
+func getDockerClient() (client.APIClient, context.Context, error) {
+	dockerClientLock.Lock()
+	defer dockerClientLock.Unlock()
 	if dockerClient == nil {
-		os.Setenv("DOCKER_API_VERSION", minimumDockerVersion)
-		newDockerClient, err := client.NewEnvClient()
+		newDockerClient, err := newDockerClient()
 		if err != nil {
 			return nil, nil, err
 		}
@@ -281,6 +248,11 @@ func getDockerClient() (*client.Client, context.Context, error) {
 	return dockerClient, dockerContext, nil
 }
 
+// newDockerClient returns a client configured from the environment (DOCKER_HOST, DOCKER_CERT_PATH...).
+func newDockerClient() (client.APIClient, error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithVersion(minimumDockerVersion))
+}
+
 // Returns the image name to use
 // If docker-image-build option has been set, an image is dynamically built and the resulting image digest is returned
 func (docker *DockerConfig) getImage() (name string) {
@@ -302,6 +274,11 @@ func getImageSummary(imageName string) (*types.ImageSummary, error) {
 	if err != nil {
 		return nil, err
 	}
+	return findImage(ctx, cli, imageName)
+}
+
+// findImage returns the image matching the name, or nil if it has not been pulled.
+func findImage(ctx context.Context, cli client.APIClient, imageName string) (*types.ImageSummary, error) {
 	// Find image
 	filters := filters.NewArgs()
 	filters.Add("reference", imageName)
@@ -368,6 +345,11 @@ func getImageHash(imageName string) (string, error) {
 	return "", nil
 }
 
+// runDocker runs a docker command and returns its output. The error contains the docker error message.
+func runDocker(args ...string) (string, error) {
+	return executeDocker(context.Background(), execExecutor{}, args...)
+}
+
 func checkImage(image string) bool {
 	var out bytes.Buffer
 	dockerCmd := exec.Command("docker", []string{"images", "-q", image}...)
@@ -393,10 +375,10 @@ func deleteImage(id string) (error) {
 	}
 	for _, item := range items {
 		if item.Untagged != "" {
-			os.Stdout.WriteString(fmt.Sprintf("Untagged %s\n", item.Untagged))
+			logrus.Infof("Untagged %s", item.Untagged)
 		}
 		if item.Deleted != "" {
-			os.Stdout.WriteString(fmt.Sprintf("Deleted %s\n", item.Deleted))
+			logrus.Infof("Deleted %s", item.Deleted)
 		}
 	}
 	return nil
@@ -474,17 +456,37 @@ func (config *DockerConfig) prune(images ...string) error{
 	danglingFilters := filters.NewArgs()
 	danglingFilters.Add("dangling", "true")
 	if _, err := cli.ImagesPrune(ctx, danglingFilters); err != nil {
-		os.Stderr.WriteString(fmt.Sprintf("Error pruning dangling images (Untagged):", err))
+		os.Stderr.WriteString(fmt.Sprintf("Error pruning dangling images (Untagged): %v", err))
 	}
 	if _, err := cli.ContainersPrune(ctx, filters.Args{}); err != nil {
-		os.Stderr.WriteString(fmt.Sprintf("Error pruning unused containers:", err))
+		os.Stderr.WriteString(fmt.Sprintf("Error pruning unused containers: %v", err))
 	}
 
 	return nil
 }
 
-func getEnviron(noHome bool) (result []string) {
-	for _, env := range os.Environ() {
+// getHostEnvironment returns the host environment overridden by the profile environment.
+func (config *DockerConfig) getHostEnvironment(hostEnviron []string) []string {
+	environ := make([]string, 0, len(hostEnviron)+len(config.Environment))
+	for _, env := range hostEnviron {
+		if _, overridden := config.Environment[strings.SplitN(env, "=", 2)[0]]; !overridden {
+			environ = append(environ, env)
+		}
+	}
+
+	keys := make([]string, 0, len(config.Environment))
+	for key := range config.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, config.Environment[key]))
+	}
+	return environ
+}
+
+func getEnviron(environ []string, noHome bool) (result []string) {
+	for _, env := range environ {
 		split := strings.Split(env, "=")
 		varName := strings.TrimSpace(split[0])
 		varUpper := strings.ToUpper(varName)
@@ -527,19 +529,10 @@ func getPathConversionFunction() func(string) string {
 }
 var convertDrive = getPathConversionFunction()
 
-func runCommands(commands []string) error {
+func runCommands(ctx context.Context, executor Executor, commands []string, streams Streams, env []string, dir string) error {
 	for _, script := range commands {
-		cmd, tempFile, err := utils.GetCommandFromString(script)
-		if err != nil {
-			return err
-		}
-		if tempFile != "" {
-			defer func() { os.Remove(tempFile) }()
-		}
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		command := Command{Script: script, Env: env, Dir: dir, Stdin: streams.In, Stdout: streams.Out, Stderr: streams.Err}
+		if err := executor.Run(ctx, command); err != nil {
 			return err
 		}
 	}
@@ -2,15 +2,54 @@ package runcontainer
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 )
 
-func getDockerMountArgs() []string {
+// dockerDesktopSockets are the sockets of Docker Desktop on the host relative to the home folder, /var/run/docker.sock
+// links to one of them.
+var dockerDesktopSockets = []string{
+	".docker/run/docker.sock",
+	"Library/Containers/com.docker.docker/Data/docker.sock",
+	"Library/Containers/com.docker.docker/Data/docker.raw.sock",
+}
+
+func findDefaultDockerSocket(environ []string) (string, error) {
+	// The socket is mounted from the Docker Desktop VM, it cannot be checked on the host
+	return dockerSocketFile, nil
+}
+
+func getDockerMountArgs(socket string) ([]string, error) {
 	// MacOS has peculiar permissions, so mounting /var/run/docker.sock doesn't work.
 	// See: https://github.com/docker/for-mac/issues/4755#issuecomment-726351209
 	// We mount the raw socket directly from the VM, which has group 'root' in the VM, so we add this group to the user.
-	return []string{"-v", getDockerSocketMount(), "--group-add", "root"}
+	return []string{"-v", getDockerSocketMount(socket), "--group-add", "root"}, nil
 }
 
-func getDockerSocketMount() string {
-	return fmt.Sprintf("%[1]s.raw:%[1]s", dockerSocketFile)
-}
\ No newline at end of file
+// getDockerSocketMount returns the mount of a socket. The raw socket of the VM is only mounted for Docker Desktop, the
+// other sockets (e.g. colima or OrbStack) are mounted as they are.
+func getDockerSocketMount(socket string) string {
+	home, _ := os.UserHomeDir()
+	if isDockerDesktopSocket(socket, home) {
+		return fmt.Sprintf("%[1]s.raw:%[1]s", dockerSocketFile)
+	}
+	return fmt.Sprintf("%[1]s:%[1]s", socket)
+}
+
+// isDockerDesktopSocket returns true if the socket is a socket of Docker Desktop or links to one. The default socket is
+// the socket of Docker Desktop unless it links to another one.
+func isDockerDesktopSocket(socket, home string) bool {
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := filepath.EvalSymlinks(socket); err == nil {
+			socket = target
+		}
+	} else if socket == dockerSocketFile {
+		return true
+	}
+	for _, desktopSocket := range dockerDesktopSockets {
+		if socket == filepath.Join(home, desktopSocket) {
+			return true
+		}
+	}
+	return false
+}
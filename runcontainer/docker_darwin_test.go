@@ -0,0 +1,48 @@
+package runcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsDockerDesktopSocket(t *testing.T) {
+	// The temporary folder of macOS is a link, the targets of the links are compared to the home folder
+	home, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, socket := range []string{".docker/run/docker.sock", ".colima/default/docker.sock", ".orbstack/run/docker.sock"} {
+		fileName := filepath.Join(home, socket)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(fileName, filepath.Join(home, filepath.Base(filepath.Dir(filepath.Dir(socket)))+".sock")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		socket string
+		want   bool
+	}{
+		{filepath.Join(home, ".docker/run/docker.sock"), true},
+		{filepath.Join(home, "Library/Containers/com.docker.docker/Data/docker.sock"), true},
+		{filepath.Join(home, ".docker.sock"), true},
+		{filepath.Join(home, ".colima/default/docker.sock"), false},
+		{filepath.Join(home, ".colima.sock"), false},
+		{filepath.Join(home, ".orbstack/run/docker.sock"), false},
+		{filepath.Join(home, ".orbstack.sock"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.socket, func(t *testing.T) {
+			if got := isDockerDesktopSocket(tt.socket, home); got != tt.want {
+				t.Errorf("isDockerDesktopSocket(%s) = %v, want %v", tt.socket, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,3 +1,4 @@
+//go:build linux
 // +build linux
 
 package runcontainer
@@ -10,15 +11,34 @@ import (
 
 const dockerSocketMountPattern = "%[1]s:%[1]s"
 
-func getDockerMountArgs() []string {
-	return []string{"-v", getDockerSocketMount(), "--group-add", getDockerGroup()}
+func findDefaultDockerSocket(environ []string) (string, error) {
+	return findDockerSocket(getDockerSocketCandidates(environ))
 }
 
-func getDockerSocketMount() string {
-	return fmt.Sprintf(dockerSocketMountPattern, dockerSocketFile)
+func getDockerMountArgs(socket string) ([]string, error) {
+	group, err := getDockerGroup(socket)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"-v", getDockerSocketMount(socket), "--group-add", group}, nil
 }
 
-func getDockerGroup() string {
-	s := must(os.Stat(dockerSocketFile)).(os.FileInfo)
-	return fmt.Sprintf("%v", s.Sys().(*syscall.Stat_t).Gid)
-}
\ No newline at end of file
+func getDockerSocketMount(socket string) string {
+	return fmt.Sprintf(dockerSocketMountPattern, socket)
+}
+
+func getDockerGroup(socket string) (string, error) {
+	s, err := os.Stat(socket)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("docker socket %s not found, set docker-host to the socket of the daemon", socket)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to access docker socket %s: %v", socket, err)
+	}
+	stat := s.Sys().(*syscall.Stat_t)
+	if uid := os.Getuid(); uid != 0 && int(stat.Uid) == uid {
+		// A rootless daemon maps the user owning its socket to root in the containers
+		return "0", nil
+	}
+	return fmt.Sprintf("%v", stat.Gid), nil
+}
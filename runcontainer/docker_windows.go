@@ -4,14 +4,19 @@ import "fmt"
 
 const dockerSocketMountPattern = "/%[1]s:%[1]s"
 
-func getDockerMountArgs() []string {
-	return []string{"-v", getDockerSocketMount(), "--group-add", getDockerGroup()}
+func findDefaultDockerSocket(environ []string) (string, error) {
+	// The socket is mounted from the Docker Desktop VM, it cannot be checked on the host
+	return dockerSocketFile, nil
 }
 
-func getDockerSocketMount() string {
-	return fmt.Sprintf(dockerSocketMountPattern, dockerSocketFile)
+func getDockerMountArgs(socket string) ([]string, error) {
+	return []string{"-v", getDockerSocketMount(socket), "--group-add", getDockerGroup()}, nil
+}
+
+func getDockerSocketMount(socket string) string {
+	return fmt.Sprintf(dockerSocketMountPattern, socket)
 }
 
 func getDockerGroup() string {
 	return "root"
-}
\ No newline at end of file
+}
@@ -0,0 +1,303 @@
+package runcontainer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerEndpoint is the docker daemon of a profile. The zero value is the daemon configured by the environment
+// (DOCKER_HOST, DOCKER_CONTEXT...).
+type dockerEndpoint struct {
+	// Host is the address of the daemon (unix://, npipe://, tcp:// or ssh://)
+	Host string
+	// Context is the docker context of the daemon, used instead of Host
+	Context string
+	// CertPath is the folder containing ca.pem, cert.pem and key.pem to connect to a tcp:// host with TLS
+	CertPath  string
+	TLSVerify bool
+}
+
+// getDockerEndpoint returns the docker daemon selected by the profile.
+func (config *DockerConfig) getDockerEndpoint() (dockerEndpoint, error) {
+	endpoint := dockerEndpoint{Host: config.DockerHost, Context: config.DockerContext, CertPath: config.DockerCertPath, TLSVerify: config.DockerTLSVerify}
+	if endpoint.Host != "" && endpoint.Context != "" {
+		return endpoint, fmt.Errorf("docker-host and docker-context cannot be both set")
+	}
+	if endpoint.CertPath != "" && !strings.HasPrefix(endpoint.Host, "tcp://") {
+		return endpoint, fmt.Errorf("docker-cert-path is only used with a tcp:// docker-host")
+	}
+	if endpoint.TLSVerify && endpoint.CertPath == "" {
+		// The certificate of the daemon would not be verified
+		return endpoint, fmt.Errorf("docker-tls-verify requires the docker-cert-path containing the CA certificate")
+	}
+	if endpoint.Host != "" {
+		if _, err := client.ParseHostURL(endpoint.Host); err != nil {
+			return endpoint, fmt.Errorf("invalid docker-host '%s': %v", endpoint.Host, err)
+		}
+	}
+	return endpoint, nil
+}
+
+// isDefault returns true if the endpoint is configured by the environment.
+func (endpoint dockerEndpoint) isDefault() bool {
+	return endpoint == dockerEndpoint{}
+}
+
+// getCLIOptions returns the global options of the docker CLI selecting the daemon.
+func (endpoint dockerEndpoint) getCLIOptions() []string {
+	switch {
+	case endpoint.Context != "":
+		return []string{"--context", endpoint.Context}
+	case endpoint.Host == "":
+		return nil
+	}
+
+	options := []string{"--host", endpoint.Host}
+	if endpoint.CertPath != "" {
+		options = append(options,
+			"--tlscacert", filepath.Join(endpoint.CertPath, "ca.pem"),
+			"--tlscert", filepath.Join(endpoint.CertPath, "cert.pem"),
+			"--tlskey", filepath.Join(endpoint.CertPath, "key.pem"),
+		)
+		if endpoint.TLSVerify {
+			options = append(options, "--tlsverify")
+		} else {
+			options = append(options, "--tls")
+		}
+	}
+	return options
+}
+
+// resolve returns the daemon the docker CLI run with the endpoint options and the environment connects to, as a host.
+// As with the docker CLI, DOCKER_HOST has precedence over DOCKER_CONTEXT and the current context of the docker config,
+// and the certificates of DOCKER_CERT_PATH are only used if DOCKER_TLS_VERIFY is set. The default context is also
+// configured by DOCKER_HOST. The host is empty for the default daemon.
+func (endpoint dockerEndpoint) resolve(environ []string) (dockerEndpoint, error) {
+	if endpoint.isDefault() || endpoint.Context == "default" {
+		if host := lookupEnv(environ, "DOCKER_HOST"); host != "" {
+			endpoint = dockerEndpoint{Host: host}
+			if lookupEnv(environ, "DOCKER_TLS_VERIFY") != "" {
+				endpoint.CertPath, endpoint.TLSVerify = lookupEnv(environ, "DOCKER_CERT_PATH"), true
+				if endpoint.CertPath == "" {
+					configFolder, err := getDockerConfigFolder(environ)
+					if err != nil {
+						return endpoint, err
+					}
+					endpoint.CertPath = configFolder
+				}
+			}
+			return endpoint, nil
+		}
+	}
+	if endpoint.isDefault() {
+		if endpoint.Context = lookupEnv(environ, "DOCKER_CONTEXT"); endpoint.Context == "" {
+			var err error
+			if endpoint.Context, err = readCurrentDockerContext(environ); err != nil {
+				return endpoint, err
+			}
+		}
+	}
+
+	if endpoint.Context != "" {
+		host, certPath, tlsVerify, err := readDockerContext(environ, endpoint.Context)
+		if err != nil {
+			return endpoint, err
+		}
+		endpoint = dockerEndpoint{Host: host, CertPath: certPath, TLSVerify: tlsVerify}
+	}
+	return endpoint, nil
+}
+
+// newClient returns an API client connected to the daemon of a resolved endpoint.
+func (endpoint dockerEndpoint) newClient() (client.APIClient, error) {
+	host := endpoint.Host
+	if host == "" {
+		host = client.DefaultDockerHost
+	}
+
+	options := []client.Opt{client.WithVersion(minimumDockerVersion)}
+	if endpoint.CertPath != "" {
+		tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             filepath.Join(endpoint.CertPath, "ca.pem"),
+			CertFile:           filepath.Join(endpoint.CertPath, "cert.pem"),
+			KeyFile:            filepath.Join(endpoint.CertPath, "key.pem"),
+			InsecureSkipVerify: !endpoint.TLSVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, CheckRedirect: client.CheckRedirect}))
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		// Like the docker CLI, the API is reached through docker system dial-stdio run on the remote host
+		dialer, err := newSSHDialer(host)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.WithHost("http://docker.example.com"), client.WithDialContext(dialer))
+	} else {
+		options = append(options, client.WithHost(host))
+	}
+	return client.NewClientWithOpts(options...)
+}
+
+// getSharedClient returns the client of the endpoint used by the package level functions, they run the docker CLI in
+// the environment of the process.
+func (endpoint dockerEndpoint) getSharedClient() (client.APIClient, error) {
+	resolved, err := endpoint.resolve(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	return resolved.newClient()
+}
+
+// getDockerConfigFolder returns the folder of the docker CLI configuration (i.e. DOCKER_CONFIG or ~/.docker).
+func getDockerConfigFolder(environ []string) (string, error) {
+	if configFolder := lookupEnv(environ, "DOCKER_CONFIG"); configFolder != "" {
+		return configFolder, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// readCurrentDockerContext returns the context selected by docker context use, empty if none.
+func readCurrentDockerContext(environ []string) (string, error) {
+	configFolder, err := getDockerConfigFolder(environ)
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(configFolder, "config.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", fmt.Errorf("invalid docker configuration %s: %v", filepath.Join(configFolder, "config.json"), err)
+	}
+	return config.CurrentContext, nil
+}
+
+// readDockerContext returns the daemon of a docker context from the metadata stored by docker context create in the
+// docker configuration of the environment. The host is empty for the default context.
+func readDockerContext(environ []string, name string) (host, certPath string, tlsVerify bool, err error) {
+	if name == "default" {
+		return "", "", false, nil
+	}
+
+	configFolder, err := getDockerConfigFolder(environ)
+	if err != nil {
+		return "", "", false, err
+	}
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	content, err := ioutil.ReadFile(filepath.Join(configFolder, "contexts", "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return "", "", false, fmt.Errorf("docker context %s not found", name)
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return "", "", false, fmt.Errorf("invalid docker context %s: %v", name, err)
+	}
+	endpoint, found := meta.Endpoints["docker"]
+	if !found || endpoint.Host == "" {
+		return "", "", false, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+
+	tlsFolder := filepath.Join(configFolder, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsFolder); err == nil {
+		certPath = tlsFolder
+	}
+	return endpoint.Host, certPath, !endpoint.SkipTLSVerify, nil
+}
+
+// newSSHDialer returns a dialer connecting to the daemon of a ssh:// host through docker system dial-stdio.
+func newSSHDialer(host string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	if hostURL.Path != "" && hostURL.Path != "/" {
+		return nil, fmt.Errorf("invalid docker-host '%s': ssh hosts cannot have a path", host)
+	}
+
+	args := []string{}
+	if hostURL.User != nil {
+		args = append(args, "-l", hostURL.User.Username())
+	}
+	if port := hostURL.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", hostURL.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cmd := exec.Command("ssh", args...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		// ssh exits when the client closes the connection, and the connection is closed when ssh exits
+		local, remote := net.Pipe()
+		go func() {
+			io.Copy(stdin, remote)
+			stdin.Close()
+		}()
+		go func() {
+			io.Copy(remote, stdout)
+			remote.Close()
+			cmd.Wait()
+		}()
+		return local, nil
+	}, nil
+}
+
+// dockerExecutor adds the options selecting the daemon of the profile to the docker commands.
+type dockerExecutor struct {
+	Executor
+	options []string
+}
+
+func (executor dockerExecutor) Run(ctx context.Context, command Command) error {
+	if command.Script == "" && command.Name == "docker" && len(executor.options) > 0 {
+		command.Args = append(append([]string{}, executor.options...), command.Args...)
+	}
+	return executor.Executor.Run(ctx, command)
+}
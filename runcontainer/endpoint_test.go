@@ -0,0 +1,175 @@
+package runcontainer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/docker/docker/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDockerEndpointCLIOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile DockerConfig
+		want    []string
+	}{
+		{"environment", DockerConfig{}, nil},
+		{"unix", DockerConfig{DockerHost: "unix:///run/user/1000/docker.sock"}, []string{"--host", "unix:///run/user/1000/docker.sock"}},
+		{"ssh", DockerConfig{DockerHost: "ssh://builder@build-box:2222"}, []string{"--host", "ssh://builder@build-box:2222"}},
+		{"context", DockerConfig{DockerContext: "build-box"}, []string{"--context", "build-box"}},
+		{"tls", DockerConfig{DockerHost: "tcp://build-box:2376", DockerCertPath: "/certs", DockerTLSVerify: true}, []string{
+			"--host", "tcp://build-box:2376",
+			"--tlscacert", filepath.Join("/certs", "ca.pem"), "--tlscert", filepath.Join("/certs", "cert.pem"), "--tlskey", filepath.Join("/certs", "key.pem"),
+			"--tlsverify",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, err := tt.profile.getDockerEndpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := endpoint.getCLIOptions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("options = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []DockerConfig{
+		{DockerHost: "tcp://build-box:2376", DockerContext: "build-box"},
+		{DockerHost: "ssh://build-box", DockerCertPath: "/certs"},
+		{DockerHost: "build-box"},
+		{DockerHost: "tcp://build-box:2376", DockerTLSVerify: true},
+	} {
+		if _, err := invalid.getDockerEndpoint(); err == nil {
+			t.Errorf("%+v should be rejected", invalid)
+		}
+	}
+}
+
+func TestReadDockerContext(t *testing.T) {
+	folder := writeDockerContext(t)
+	environ := []string{"DOCKER_CONFIG=" + folder}
+
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte("build-box")))
+	host, certPath, tlsVerify, err := readDockerContext(environ, "build-box")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "tcp://build-box:2376" || certPath != filepath.Join(folder, "contexts", "tls", id, "docker") || !tlsVerify {
+		t.Errorf("context is %s %s %v", host, certPath, tlsVerify)
+	}
+	if _, _, _, err := readDockerContext(environ, "unknown"); err == nil {
+		t.Error("an unknown context should be rejected")
+	}
+}
+
+func TestRunnerClientEnvironment(t *testing.T) {
+	folder := writeDockerContext(t)
+	current := writeDockerContext(t)
+	if err := ioutil.WriteFile(filepath.Join(current, "config.json"), []byte(`{"currentContext":"build-box"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte("build-box")))
+	tlsFolder := filepath.Join(folder, "contexts", "tls", id, "docker")
+	// The process environment is not the environment of the docker CLI
+	defer os.Setenv("DOCKER_HOST", os.Getenv("DOCKER_HOST"))
+	os.Setenv("DOCKER_HOST", "tcp://process:2375")
+
+	tests := []struct {
+		name        string
+		environ     []string
+		profile     DockerConfig
+		want        dockerEndpoint
+		wantCLIHost string
+	}{
+		{"default", []string{"DOCKER_CONFIG=" + folder}, DockerConfig{}, dockerEndpoint{}, client.DefaultDockerHost},
+		{"host", []string{"DOCKER_HOST=tcp://build-box:2375"}, DockerConfig{}, dockerEndpoint{Host: "tcp://build-box:2375"}, "tcp://build-box:2375"},
+		{"profile environment", []string{"DOCKER_HOST=tcp://build-box:2375"}, DockerConfig{Environment: map[string]string{"DOCKER_HOST": "tcp://remote:2375"}}, dockerEndpoint{Host: "tcp://remote:2375"}, "tcp://remote:2375"},
+		{"cert path without tls", []string{"DOCKER_HOST=tcp://build-box:2376", "DOCKER_CERT_PATH=/certs"}, DockerConfig{}, dockerEndpoint{Host: "tcp://build-box:2376"}, "tcp://build-box:2376"},
+		{"tls", []string{"DOCKER_HOST=tcp://build-box:2376", "DOCKER_TLS_VERIFY=1", "DOCKER_CERT_PATH=/certs"}, DockerConfig{}, dockerEndpoint{Host: "tcp://build-box:2376", CertPath: "/certs", TLSVerify: true}, ""},
+		{"tls default cert path", []string{"DOCKER_HOST=tcp://build-box:2376", "DOCKER_TLS_VERIFY=1", "DOCKER_CONFIG=" + folder}, DockerConfig{}, dockerEndpoint{Host: "tcp://build-box:2376", CertPath: folder, TLSVerify: true}, ""},
+		{"context", []string{"DOCKER_CONFIG=" + folder, "DOCKER_CONTEXT=build-box"}, DockerConfig{}, dockerEndpoint{Host: "tcp://build-box:2376", CertPath: tlsFolder, TLSVerify: true}, ""},
+		{"host over context", []string{"DOCKER_CONFIG=" + folder, "DOCKER_CONTEXT=build-box", "DOCKER_HOST=tcp://other:2375"}, DockerConfig{}, dockerEndpoint{Host: "tcp://other:2375"}, "tcp://other:2375"},
+		{"current context", nil, DockerConfig{Environment: map[string]string{"DOCKER_CONFIG": current}}, dockerEndpoint{Host: "tcp://build-box:2376", CertPath: filepath.Join(current, "contexts", "tls", id, "docker"), TLSVerify: true}, ""},
+		{"profile context", []string{"DOCKER_CONFIG=" + folder}, DockerConfig{DockerContext: "build-box"}, dockerEndpoint{Host: "tcp://build-box:2376", CertPath: tlsFolder, TLSVerify: true}, ""},
+		{"profile default context", []string{"DOCKER_HOST=tcp://build-box:2375"}, DockerConfig{DockerContext: "default"}, dockerEndpoint{Host: "tcp://build-box:2375"}, "tcp://build-box:2375"},
+		{"profile host", []string{"DOCKER_HOST=tcp://other:2375"}, DockerConfig{DockerHost: "tcp://build-box:2375"}, dockerEndpoint{Host: "tcp://build-box:2375"}, "tcp://build-box:2375"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, err := tt.profile.getDockerEndpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The environment of the docker CLI selects the daemon of the API client
+			environ := tt.profile.getHostEnvironment(tt.environ)
+			resolved, err := endpoint.resolve(environ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved != tt.want {
+				t.Errorf("resolved endpoint = %+v, want %+v", resolved, tt.want)
+			}
+			if tt.wantCLIHost == "" {
+				return
+			}
+			cli, err := NewRunner(WithEnvironment(tt.environ)).getClient(endpoint, environ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cli.DaemonHost() != tt.wantCLIHost {
+				t.Errorf("client host = %s, want %s", cli.DaemonHost(), tt.wantCLIHost)
+			}
+		})
+	}
+}
+
+// writeDockerContext writes the build-box context created by docker context create in a docker config folder.
+func writeDockerContext(t *testing.T) string {
+	t.Helper()
+	folder := t.TempDir()
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte("build-box")))
+	for name, content := range map[string]string{
+		filepath.Join("meta", id, "meta.json"):         `{"Name":"build-box","Endpoints":{"docker":{"Host":"tcp://build-box:2376","SkipTLSVerify":false}}}`,
+		filepath.Join("tls", id, "docker", "ca.pem"):   "",
+		filepath.Join("tls", id, "docker", "cert.pem"): "",
+	} {
+		fileName := filepath.Join(folder, "contexts", name)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return folder
+}
+
+func TestRunnerDockerHost(t *testing.T) {
+	run := newTestRun(t)
+	profile := run.profile()
+	profile.DockerHost = "ssh://builder@build-box"
+	profile.Services = map[string]*ServiceConfig{"db": {Image: "postgres:13"}}
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		if len(command.Args) > 2 && command.Args[2] == "inspect" && command.Args[3] == "--format" {
+			fmt.Fprint(command.Stdout, "running")
+		}
+		return nil
+	}
+
+	if _, err := run.runner.Run(context.Background(), profile, []string{"make"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range run.executor.Commands() {
+		if command.Name == "docker" && (len(command.Args) < 2 || command.Args[0] != "--host" || command.Args[1] != profile.DockerHost) {
+			t.Errorf("%s is not run on the host of the profile", command)
+		}
+	}
+}
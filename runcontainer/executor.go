@@ -0,0 +1,171 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/coveooss/gotemplate/v3/utils"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Command is a process run by the runner on the host: docker or a hook script.
+type Command struct {
+	// Name and Args are the program to run and its arguments
+	Name string
+	Args []string
+	// Script is a host script (i.e. a hook) run instead of Name when set
+	Script string
+
+	Env    []string
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// String returns the command line.
+func (command Command) String() string {
+	if command.Script != "" {
+		return command.Script
+	}
+	return strings.Join(append([]string{command.Name}, command.Args...), " ")
+}
+
+// Executor runs the commands of the runner. The command must be killed if the context is done before it completes.
+// The error returned for a command that failed should implement ExitCode() int (as *exec.ExitError does) to report
+// its exit code.
+type Executor interface {
+	Run(ctx context.Context, command Command) error
+}
+
+// exitCoder is implemented by the errors of the commands that returned an exit code.
+type exitCoder interface {
+	ExitCode() int
+}
+
+// execExecutor runs the commands as processes.
+type execExecutor struct{}
+
+func (execExecutor) Run(ctx context.Context, command Command) error {
+	var cmd *exec.Cmd
+	if command.Script != "" {
+		scriptCmd, tempFile, err := utils.GetCommandFromString(command.Script)
+		if err != nil {
+			return err
+		}
+		if tempFile != "" {
+			defer os.Remove(tempFile)
+		}
+		cmd = exec.Command(scriptCmd.Path, scriptCmd.Args[1:]...)
+	} else {
+		cmd = exec.CommandContext(ctx, command.Name, command.Args...)
+	}
+
+	cmd.Env = command.Env
+	cmd.Dir = command.Dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = command.Stdin, command.Stdout, command.Stderr
+	if command.Script == "" {
+		return cmd.Run()
+	}
+
+	// The processes started by a hook are killed with it, they would otherwise keep running (and keep its output
+	// open) once the hook times out
+	restoreTerminal := setProcessGroup(cmd)
+	defer restoreTerminal()
+	return runProcessGroup(ctx, cmd)
+}
+
+// runProcessGroup runs a command started in its own process group and kills the whole group if the context is done
+// before the command completes.
+func runProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd.Process)
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
+}
+
+// FakeExecutor records the commands instead of running them. It is meant to test the code using a Runner.
+type FakeExecutor struct {
+	// Handler, if set, is called for every command and returns its result (i.e. an ExitError or output written to
+	// the command streams).
+	Handler func(ctx context.Context, command Command) error
+
+	lock     sync.Mutex
+	commands []Command
+}
+
+// Run records the command and calls the handler.
+func (executor *FakeExecutor) Run(ctx context.Context, command Command) error {
+	executor.lock.Lock()
+	executor.commands = append(executor.commands, command)
+	executor.lock.Unlock()
+
+	if executor.Handler != nil {
+		return executor.Handler(ctx, command)
+	}
+	return nil
+}
+
+// Commands returns the commands run so far.
+func (executor *FakeExecutor) Commands() []Command {
+	executor.lock.Lock()
+	defer executor.lock.Unlock()
+	return append([]Command{}, executor.commands...)
+}
+
+// CommandLines returns the command lines of the commands run so far.
+func (executor *FakeExecutor) CommandLines() []string {
+	var lines []string
+	for _, command := range executor.Commands() {
+		lines = append(lines, command.String())
+	}
+	return lines
+}
+
+// ExitError is the error of a command that returned a non zero exit code.
+type ExitError struct {
+	Code int
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", err.Code)
+}
+
+// ExitCode returns the exit code of the command.
+func (err *ExitError) ExitCode() int {
+	return err.Code
+}
+
+// getExitCode returns the exit code corresponding to the error of a command.
+func getExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(exitCoder); ok {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// executeDocker runs a docker command and returns its output. The error contains the docker error message.
+func executeDocker(ctx context.Context, executor Executor, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := Command{Name: "docker", Args: args, Stdout: &stdout, Stderr: &stderr}
+	if err := executor.Run(ctx, command); err != nil {
+		return "", fmt.Errorf("docker %s failed: %v %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,28 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExecExecutorKillsHookProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The background process keeps the output open, the hook only completes once it is killed too
+	var output bytes.Buffer
+	start := time.Now()
+	err := execExecutor{}.Run(ctx, Command{Script: "sleep 30 & sleep 30", Stdout: &output, Stderr: &output})
+	if err == nil {
+		t.Error("the hook should be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the hook processes were not killed after the timeout, the hook ran for %v", elapsed)
+	}
+}
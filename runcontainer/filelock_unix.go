@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package runcontainer
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// lockFile takes the exclusive advisory lock of an open file, waiting for it if wait is set. It returns false if the
+// lock is held by another open file and wait is not set. The lock is released when the file is closed.
+func lockFile(file *os.File, wait bool) (bool, error) {
+	how := unix.LOCK_EX
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+	err := unix.Flock(int(file.Fd()), how)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
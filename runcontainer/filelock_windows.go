@@ -0,0 +1,24 @@
+package runcontainer
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockOffset is the offset of the locked byte. Windows locks prevent other processes from reading the locked bytes,
+// the byte after the content (which is never written) is locked so the file can still be read.
+const lockOffset = 1 << 31
+
+// lockFile takes the exclusive lock of an open file, waiting for it if wait is set. It returns false if the lock is
+// held by another open file and wait is not set. The lock is released when the file is closed.
+func lockFile(file *os.File, wait bool) (bool, error) {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{Offset: lockOffset})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
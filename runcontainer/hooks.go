@@ -0,0 +1,102 @@
+package runcontainer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// HookStage identifies when hook commands are run
+type HookStage string
+
+// Hook stages
+const (
+	HookBefore        HookStage = "before"
+	HookAfterSuccess  HookStage = "after-success"
+	HookAfterFailure  HookStage = "after-failure"
+	HookAlways        HookStage = "always"
+	HookContainerInit HookStage = "container-init"
+)
+
+// Hooks are commands run on the host around the container execution, except ContainerInit commands that are run
+// inside the container (with /bin/sh) before the actual command.
+type Hooks struct {
+	Before        []string `yaml:"before,omitempty" json:"before,omitempty" hcl:"before,omitempty"`
+	AfterSuccess  []string `yaml:"after-success,omitempty" json:"after-success,omitempty" hcl:"after-success,omitempty"`
+	AfterFailure  []string `yaml:"after-failure,omitempty" json:"after-failure,omitempty" hcl:"after-failure,omitempty"`
+	Always        []string `yaml:"always,omitempty" json:"always,omitempty" hcl:"always,omitempty"`
+	ContainerInit []string `yaml:"container-init,omitempty" json:"container-init,omitempty" hcl:"container-init,omitempty"`
+}
+
+// getHookCommands returns the commands of a stage. RunBeforeCommands are run with the before stage and
+// RunAfterCommands are run with the always stage.
+func (config *DockerConfig) getHookCommands(stage HookStage) []string {
+	hooks := config.Hooks
+	if hooks == nil {
+		hooks = &Hooks{}
+	}
+
+	switch stage {
+	case HookBefore:
+		return append(append([]string{}, config.RunBeforeCommands...), hooks.Before...)
+	case HookAfterSuccess:
+		return hooks.AfterSuccess
+	case HookAfterFailure:
+		return hooks.AfterFailure
+	case HookAlways:
+		return append(append([]string{}, config.RunAfterCommands...), hooks.Always...)
+	case HookContainerInit:
+		return hooks.ContainerInit
+	}
+	return nil
+}
+
+// runHooks runs the host commands of a stage. Except for the before stage, the commands receive the container exit
+// code in RUNCONTAINER_EXIT_CODE.
+func (runner *Runner) runHooks(ctx context.Context, config *DockerConfig, stage HookStage, environ []string, dir string, exitCode int) error {
+	commands := config.getHookCommands(stage)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	env := append(append([]string{}, environ...), fmt.Sprintf("RUNCONTAINER_HOOK_STAGE=%s", stage))
+	if stage != HookBefore {
+		env = append(env, fmt.Sprintf("RUNCONTAINER_EXIT_CODE=%d", exitCode))
+	}
+	for _, command := range commands {
+		runner.logger.WithField("stage", stage).Debugf("Running hook: %s", command)
+	}
+	if err := runCommands(ctx, runner.executor, commands, runner.streams, env, dir); err != nil {
+		return fmt.Errorf("%s hook failed: %v", stage, err)
+	}
+	return nil
+}
+
+// runFinalHooks runs the after-success or after-failure hooks then the always hooks and returns the resulting exit
+// code. A failing hook turns a successful run into a failure.
+func (runner *Runner) runFinalHooks(ctx context.Context, config *DockerConfig, environ []string, dir string, exitCode int) int {
+	stage := HookAfterSuccess
+	if exitCode != 0 {
+		stage = HookAfterFailure
+	}
+
+	for _, stage := range []HookStage{stage, HookAlways} {
+		if err := runner.runHooks(ctx, config, stage, environ, dir, exitCode); err != nil {
+			fmt.Fprintln(runner.streams.Err, err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
+
+// getContainerInitScript returns the shell script that runs the container-init commands and then replaces itself by
+// the image entry point followed by the actual command received as arguments.
+func getContainerInitScript(commands, entrypoint []string) string {
+	exec := "exec"
+	for _, arg := range entrypoint {
+		exec += " " + quoteShell(arg)
+	}
+	return fmt.Sprintf("set -e\n%s\n%s \"$@\"", strings.Join(commands, "\n"), exec)
+}
@@ -0,0 +1,161 @@
+package runcontainer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockMode defines which runs are serialized by the lock of a profile
+type LockMode string
+
+// Lock modes
+const (
+	// LockNone does not lock (default)
+	LockNone LockMode = "none"
+	// LockWorkspace serializes the runs of all the profiles using the same workspace root
+	LockWorkspace LockMode = "workspace"
+	// LockProfile serializes the runs of the same profile using the same workspace root
+	LockProfile LockMode = "profile"
+)
+
+const lockPollInterval = 500 * time.Millisecond
+
+// LockHolder describes the process holding a lock.
+type LockHolder struct {
+	PID       int       `json:"pid"`
+	User      string    `json:"user,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Workspace string    `json:"workspace,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+func (holder LockHolder) String() string {
+	return fmt.Sprintf("pid %d (%s@%s) since %s", holder.PID, holder.User, holder.Host, holder.Started.Format(time.RFC3339))
+}
+
+// DefaultLockFolder returns the folder containing the lock files (i.e. ~/.cache/runcontainer/locks).
+func DefaultLockFolder() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "runcontainer", "locks"), nil
+}
+
+// getLockFile returns the lock file of a run, or an empty string if the run is not locked.
+func getLockFile(folder string, mode LockMode, workspace, profile string) (string, error) {
+	var key string
+	switch mode {
+	case "", LockNone:
+		return "", nil
+	case LockWorkspace:
+		key = workspace
+	case LockProfile:
+		key = workspace + "\n" + profile
+	default:
+		return "", fmt.Errorf("unknown lock '%s', expected %s, %s or %s", mode, LockWorkspace, LockProfile, LockNone)
+	}
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(folder, fmt.Sprintf("%x.lock", hash[:8])), nil
+}
+
+// acquireLock takes the advisory lock of the lock file, waiting while it is held by another process. The waiting
+// function is called once with the holder of the lock if the lock is not free. The lock is released by the system if
+// the process dies, the holder written in the file is only informative.
+func acquireLock(ctx context.Context, fileName string, holder LockHolder, waiting func(LockHolder)) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	notified := false
+	for {
+		file, err := tryLock(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			if err := writeLockHolder(file, content); err != nil {
+				file.Close()
+				return nil, err
+			}
+			return func() {
+				// The file is removed before being unlocked, so a process waiting for it opens a new one
+				os.Remove(fileName)
+				file.Close()
+			}, nil
+		}
+
+		current, err := readLockHolder(fileName)
+		if err == nil && !notified {
+			waiting(current)
+			notified = true
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return nil, fmt.Errorf("lock %s is still held: %v", fileName, ctx.Err())
+			}
+			return nil, fmt.Errorf("lock is still held by %s: %v", current, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLock opens the lock file and takes its lock. It returns a nil file if the lock is held by another process.
+func tryLock(fileName string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := lockFile(file, false)
+		if err != nil || !locked {
+			file.Close()
+			return nil, err
+		}
+
+		// The holder may have removed the file between its opening and its locking, the lock of a removed file is
+		// useless and the new file is locked instead
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(fileName); err == nil && os.SameFile(info, current) {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+// writeLockHolder replaces the content of the locked file by the holder, a previous holder may have left its own.
+func writeLockHolder(file *os.File, content []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt(content, 0)
+	return err
+}
+
+// readLockHolder reads the holder of a lock. The content may be incomplete while the lock is being created, so the
+// caller should retry later on error.
+func readLockHolder(fileName string) (LockHolder, error) {
+	var holder LockHolder
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return holder, err
+	}
+	return holder, json.Unmarshal(content, &holder)
+}
@@ -0,0 +1,142 @@
+package runcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetLockFile(t *testing.T) {
+	workspace, _ := getLockFile("locks", LockWorkspace, "/home/jdoe/src", "iac")
+	otherProfile, _ := getLockFile("locks", LockWorkspace, "/home/jdoe/src", "build")
+	if workspace != otherProfile {
+		t.Errorf("workspace locks should not depend on the profile: %s != %s", workspace, otherProfile)
+	}
+	profile, _ := getLockFile("locks", LockProfile, "/home/jdoe/src", "iac")
+	otherProfile, _ = getLockFile("locks", LockProfile, "/home/jdoe/src", "build")
+	if profile == otherProfile || profile == workspace {
+		t.Errorf("profile locks should depend on the profile: %s, %s, %s", workspace, profile, otherProfile)
+	}
+	if none, err := getLockFile("locks", LockNone, "/home/jdoe/src", "iac"); none != "" || err != nil {
+		t.Errorf("runs should not be locked with %s: %q, %v", LockNone, none, err)
+	}
+	if _, err := getLockFile("locks", "global", "/home/jdoe/src", "iac"); err == nil {
+		t.Error("an unknown lock mode should be rejected")
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "locks", "test.lock")
+	host, _ := os.Hostname()
+	holder := LockHolder{PID: os.Getpid(), User: "jdoe", Host: host, Started: time.Now()}
+
+	release, err := acquireLock(context.Background(), fileName, holder, func(LockHolder) { t.Error("the lock is free") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is held by this process
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var waitedFor *LockHolder
+	_, err = acquireLock(ctx, fileName, holder, func(current LockHolder) { waitedFor = &current })
+	if err == nil || !strings.Contains(err.Error(), "still held by pid") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if waitedFor == nil || waitedFor.PID != os.Getpid() || waitedFor.User != "jdoe" {
+		t.Errorf("waiting for %+v", waitedFor)
+	}
+
+	release()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("the lock should be removed: %v", err)
+	}
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	host, _ := os.Hostname()
+	// Process ids are below the default pid_max, this one does not exist
+	stale, _ := json.Marshal(LockHolder{PID: 1 << 30, Host: host, Started: time.Now().Add(-time.Hour)})
+
+	// Lock files left by processes that died, before or after writing the holder
+	for name, content := range map[string][]byte{"holder": stale, "empty": nil} {
+		t.Run(name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "test.lock")
+			if err := ioutil.WriteFile(fileName, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			holder := LockHolder{PID: os.Getpid(), Host: host, Started: time.Now()}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			release, err := acquireLock(ctx, fileName, holder, func(LockHolder) { t.Error("the stale lock should be taken") })
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if current, err := readLockHolder(fileName); err != nil || current.PID != os.Getpid() {
+				t.Errorf("lock is held by %+v (%v)", current, err)
+			}
+		})
+	}
+}
+
+func TestAcquireLockConcurrent(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.lock")
+	var holders int32
+	var wait sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			release, err := acquireLock(context.Background(), fileName, LockHolder{PID: i}, func(LockHolder) {})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if count := atomic.AddInt32(&holders, 1); count != 1 {
+				t.Errorf("the lock is held %d times", count)
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			release()
+		}(i)
+	}
+	wait.Wait()
+}
+
+func TestRunnerLock(t *testing.T) {
+	run := newTestRun(t, WithLockTimeout(100*time.Millisecond))
+	profile := run.profile()
+	profile.Lock = LockProfile
+
+	// Another run of the profile holds the lock
+	release, err := run.runner.lock(context.Background(), LockProfile, filepath.Join(run.folder, "workspace"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run.runner.Run(context.Background(), profile, []string{"terragrunt", "apply"}); err == nil {
+		t.Error("the run should time out waiting for the lock")
+	}
+	if len(run.executor.Commands()) != 0 {
+		t.Errorf("docker should not be run while the lock is held: %v", run.executor.CommandLines())
+	}
+	if !strings.Contains(run.err.String(), "Waiting for the profile lock") {
+		t.Errorf("unexpected error output %q", run.err.String())
+	}
+
+	release()
+	if _, err := run.runner.Run(context.Background(), profile, []string{"terragrunt", "apply"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(run.runner.lockFolder, "*.lock")); len(entries) != 0 {
+		t.Errorf("the lock is not released: %v", entries)
+	}
+}
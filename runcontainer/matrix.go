@@ -0,0 +1,188 @@
+package runcontainer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MatrixConfig lists the image tags and environment values to combine when the profile is run with the matrix
+// command. Every image tag is run with every combination of environment values.
+type MatrixConfig struct {
+	ImageTags   []string            `yaml:"docker-image-tags,omitempty" json:"docker-image-tags,omitempty" hcl:"docker-image-tags,omitempty"`
+	Environment map[string][]string `yaml:"environment,omitempty" json:"environment,omitempty" hcl:"environment,omitempty"`
+}
+
+// MatrixRun is a combination of a matrix and its result once run.
+type MatrixRun struct {
+	Name     string
+	Config   *DockerConfig
+	ExitCode int
+	Duration time.Duration
+}
+
+// GetMatrixRuns returns the combinations of the profile matrix, or the profile itself if it has no matrix.
+func (config *DockerConfig) GetMatrixRuns(profile string) []*MatrixRun {
+	runs := []*MatrixRun{{Name: profile, Config: config.clone()}}
+	if config.Matrix == nil {
+		return runs
+	}
+
+	if len(config.Matrix.ImageTags) > 0 {
+		var tagRuns []*MatrixRun
+		for _, tag := range config.Matrix.ImageTags {
+			run := &MatrixRun{Name: fmt.Sprintf("%s:%s", profile, tag), Config: config.clone()}
+			run.Config.ImageTag = tag
+			tagRuns = append(tagRuns, run)
+		}
+		runs = tagRuns
+	}
+
+	keys := make([]string, 0, len(config.Matrix.Environment))
+	for key := range config.Matrix.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var envRuns []*MatrixRun
+		for _, run := range runs {
+			for _, value := range config.Matrix.Environment[key] {
+				envRun := &MatrixRun{Name: fmt.Sprintf("%s %s=%s", run.Name, key, value), Config: run.Config.clone()}
+				envRun.Config.Environment[key] = value
+				envRuns = append(envRuns, envRun)
+			}
+		}
+		runs = envRuns
+	}
+	return runs
+}
+
+// RunMatrix runs the same command for every run, with at most parallel runs at the same time. The output of each run
+// is either prefixed by its name or, if collect is set, written as a block when the run is completed. It returns 1 if a
+// run failed.
+func RunMatrix(runs []*MatrixRun, args []string, parallel int, collect bool, stdout, stderr io.Writer, options ...Option) int {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var outputLock sync.Mutex
+	var wait sync.WaitGroup
+	slots := make(chan bool, parallel)
+
+	for _, run := range runs {
+		wait.Add(1)
+		slots <- true
+		go func(run *MatrixRun) {
+			defer func() {
+				<-slots
+				wait.Done()
+			}()
+
+			// The runs are not interactive as they share the terminal
+			run.Config.DockerInteractive = false
+			run.Config.Environment["RUNCONTAINER_MATRIX_RUN"] = run.Name
+
+			var streams Streams
+			var output bytes.Buffer
+			var prefixWriters []*linePrefixWriter
+			if collect {
+				streams = Streams{Out: &output, Err: &output}
+			} else {
+				prefix := fmt.Sprintf("[%s] ", run.Name)
+				prefixWriters = []*linePrefixWriter{
+					{writer: stdout, prefix: prefix, lock: &outputLock},
+					{writer: stderr, prefix: prefix, lock: &outputLock},
+				}
+				streams = Streams{Out: prefixWriters[0], Err: prefixWriters[1]}
+			}
+
+			start := time.Now()
+			func() {
+				// A failing run must not stop the other ones
+				defer func() {
+					if err := recover(); err != nil {
+						fmt.Fprintln(streams.Err, err)
+						run.ExitCode = 1
+					}
+				}()
+				run.ExitCode = run.Config.ExecuteWithOptions(args, append(append([]Option{}, options...), WithStreams(streams))...)
+			}()
+			run.Duration = time.Since(start)
+
+			for _, writer := range prefixWriters {
+				writer.Flush()
+			}
+			if collect {
+				outputLock.Lock()
+				fmt.Fprintf(stdout, "==> %s (exit code %d) <==\n%s\n", run.Name, run.ExitCode, output.String())
+				outputLock.Unlock()
+			}
+		}(run)
+	}
+	wait.Wait()
+
+	for _, run := range runs {
+		if run.ExitCode != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// linePrefixWriter writes complete lines prefixed by a string. The lock is shared by the writers of all runs so lines
+// are never interleaved, it also protects the pending bytes of a writer used by several goroutines.
+type linePrefixWriter struct {
+	writer  io.Writer
+	prefix  string
+	lock    *sync.Mutex
+	pending []byte
+}
+
+func (writer *linePrefixWriter) Write(p []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	writer.pending = append(writer.pending, p...)
+	index := bytes.LastIndexByte(writer.pending, '\n')
+	if index < 0 {
+		return len(p), nil
+	}
+
+	lines := writer.pending[:index+1]
+	writer.pending = append([]byte(nil), writer.pending[index+1:]...)
+	if err := writer.write(lines); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line even if it is not terminated.
+func (writer *linePrefixWriter) Flush() error {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	if len(writer.pending) == 0 {
+		return nil
+	}
+	lines := append(writer.pending, '\n')
+	writer.pending = nil
+	return writer.write(lines)
+}
+
+// write must be called with the lock held.
+func (writer *linePrefixWriter) write(lines []byte) error {
+	var buffer bytes.Buffer
+	for _, line := range strings.SplitAfter(string(lines), "\n") {
+		if line != "" {
+			buffer.WriteString(writer.prefix + line)
+		}
+	}
+
+	_, err := writer.writer.Write(buffer.Bytes())
+	return err
+}
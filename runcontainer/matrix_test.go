@@ -0,0 +1,84 @@
+package runcontainer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLinePrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+		flush  string
+	}{
+		{"line", []string{"hello\n"}, "[a] hello\n", "[a] hello\n"},
+		{"lines", []string{"hello\nworld\n"}, "[a] hello\n[a] world\n", "[a] hello\n[a] world\n"},
+		{"partial line", []string{"hel", "lo"}, "", "[a] hello\n"},
+		{"split line", []string{"hel", "lo\nwor", "ld\n"}, "[a] hello\n[a] world\n", "[a] hello\n[a] world\n"},
+		{"unterminated", []string{"hello\nworld"}, "[a] hello\n", "[a] hello\n[a] world\n"},
+		{"empty line", []string{"\n", "\n"}, "[a] \n[a] \n", "[a] \n[a] \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			writer := &linePrefixWriter{writer: &output, prefix: "[a] ", lock: &sync.Mutex{}}
+			for _, data := range tt.writes {
+				if n, err := writer.Write([]byte(data)); err != nil || n != len(data) {
+					t.Fatalf("Write(%q) = %d, %v", data, n, err)
+				}
+			}
+			if output.String() != tt.want {
+				t.Errorf("output = %q, want %q", output.String(), tt.want)
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != tt.flush {
+				t.Errorf("flushed output = %q, want %q", output.String(), tt.flush)
+			}
+		})
+	}
+}
+
+func TestLinePrefixWriterConcurrent(t *testing.T) {
+	const lines = 100
+	var output bytes.Buffer
+	var lock sync.Mutex
+	writers := []*linePrefixWriter{
+		{writer: &output, prefix: "[a] ", lock: &lock},
+		{writer: &output, prefix: "[b] ", lock: &lock},
+	}
+
+	var wait sync.WaitGroup
+	for _, writer := range writers {
+		// Two goroutines per writer, as for a stream shared by the runner and the process output
+		for goroutine := 0; goroutine < 2; goroutine++ {
+			wait.Add(1)
+			go func(writer *linePrefixWriter) {
+				defer wait.Done()
+				for i := 0; i < lines; i++ {
+					// Each line is written at once so lines of the same writer are not mixed
+					writer.Write([]byte(fmt.Sprintf("line %d of %s\n", i, writer.prefix)))
+				}
+			}(writer)
+		}
+	}
+	wait.Wait()
+	for _, writer := range writers {
+		writer.Flush()
+	}
+
+	result := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(result) != 4*lines {
+		t.Fatalf("got %d lines, want %d", len(result), 4*lines)
+	}
+	for _, line := range result {
+		if !strings.HasPrefix(line, "[a] line ") && !strings.HasPrefix(line, "[b] line ") || !strings.HasSuffix(line, " of "+line[:4]) {
+			t.Errorf("line %q is interleaved", line)
+		}
+	}
+}
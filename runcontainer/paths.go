@@ -0,0 +1,313 @@
+package runcontainer
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// pathMapping associates a host path with the path where it is mounted inside the container.
+type pathMapping struct {
+	host      string
+	container string
+}
+
+// addPathMapping registers a mount unless it is mirrored (same path on both sides).
+func addPathMapping(mappings []pathMapping, host, container string) []pathMapping {
+	if host == "" || container == "" || host == container {
+		return mappings
+	}
+	return append(mappings, pathMapping{host: host, container: container})
+}
+
+// containsPath returns true if the container path is the folder or one of its subfolders.
+func containsPath(folder, path string) bool {
+	folder, path = strings.TrimSuffix(folder, "/"), strings.TrimSuffix(path, "/")
+	return path == folder || strings.HasPrefix(path, folder+"/")
+}
+
+// pathRewriter is a writer that replaces container mount paths by their host equivalent before forwarding the
+// output. Only whole path components are replaced and any other byte (terminal control sequences) is forwarded
+// untouched. Bytes that may be the beginning of a mount path are held until the next write, Flush or a short delay so
+// prompts are not stalled. Output that is not valid UTF-8 is considered binary and is no longer rewritten.
+type pathRewriter struct {
+	writer   io.Writer
+	mappings []pathMapping
+	pending  []byte
+	previous byte
+	escape   escapeState
+	binary   bool
+	// delay is the time after which the pending bytes are written if no other write completes them
+	delay time.Duration
+	timer *time.Timer
+	err   error
+	lock  sync.Mutex
+}
+
+// escapeState tracks ANSI escape sequences so that a path directly following a color code is still recognized and
+// the content of string sequences (i.e. terminal titles or hyperlinks) is never rewritten.
+type escapeState int
+
+const (
+	escapeNone escapeState = iota
+	escapeStart
+	escapeCSI
+	escapeString
+)
+
+const (
+	escapeChar = 0x1b
+	bellChar   = 0x07
+)
+
+// pathRewriterDelay is the time after which the bytes held by a path rewriter are written
+const pathRewriterDelay = 50 * time.Millisecond
+
+func newPathRewriter(writer io.Writer, mappings []pathMapping) *pathRewriter {
+	sorted := append([]pathMapping(nil), mappings...)
+	// Longest paths first so /home/user is preferred over /home
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].container) > len(sorted[j].container) })
+	return &pathRewriter{writer: writer, mappings: sorted, delay: pathRewriterDelay}
+}
+
+func (rewriter *pathRewriter) Write(p []byte) (int, error) {
+	rewriter.lock.Lock()
+	defer rewriter.lock.Unlock()
+	if rewriter.err != nil {
+		return 0, rewriter.err
+	}
+	if err := rewriter.rewrite(append(rewriter.pending, p...), false); err != nil {
+		return 0, err
+	}
+	if len(rewriter.pending) > 0 && rewriter.delay > 0 {
+		if rewriter.timer == nil {
+			rewriter.timer = time.AfterFunc(rewriter.delay, rewriter.flushPending)
+		} else {
+			rewriter.timer.Reset(rewriter.delay)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the bytes held while waiting to know if they were a mount path.
+func (rewriter *pathRewriter) Flush() error {
+	rewriter.lock.Lock()
+	defer rewriter.lock.Unlock()
+	if rewriter.timer != nil {
+		rewriter.timer.Stop()
+	}
+	if rewriter.err != nil {
+		return rewriter.err
+	}
+	return rewriter.rewrite(rewriter.pending, true)
+}
+
+// flushPending writes the pending bytes when no write completed them in time, the error is returned by the next
+// write.
+func (rewriter *pathRewriter) flushPending() {
+	rewriter.lock.Lock()
+	defer rewriter.lock.Unlock()
+	if rewriter.err == nil && len(rewriter.pending) > 0 {
+		rewriter.err = rewriter.rewrite(rewriter.pending, true)
+	}
+}
+
+func (rewriter *pathRewriter) rewrite(data []byte, final bool) error {
+	// A character split between two writes is checked once it is complete
+	split := len(data) - getIncompleteRuneLength(data)
+	if !rewriter.binary && !utf8.Valid(data[:split]) {
+		rewriter.binary = true
+	}
+	var tail []byte
+	if !final {
+		data, tail = data[:split], data[split:]
+	}
+	rewriter.pending = nil
+	if rewriter.binary {
+		// The length of binary output must not change, it is forwarded untouched
+		output := append(append([]byte(nil), data...), tail...)
+		if len(output) == 0 {
+			return nil
+		}
+		_, err := rewriter.writer.Write(output)
+		return err
+	}
+
+	output := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		if rewriter.escape != escapeNone || data[i] == escapeChar {
+			rewriter.escape = nextEscapeState(rewriter.escape, data[i])
+			// The end of an escape sequence is considered as a path boundary
+			rewriter.previous = escapeChar
+			output = append(output, data[i])
+			i++
+			continue
+		}
+		if data[i] == '/' && !isPathByte(rewriter.previous) {
+			mapping, partial := rewriter.match(data[i:], final)
+			if partial {
+				rewriter.pending = append([]byte(nil), data[i:]...)
+				break
+			}
+			if mapping != nil {
+				output = append(output, mapping.host...)
+				rewriter.previous = mapping.container[len(mapping.container)-1]
+				i += len(mapping.container)
+				continue
+			}
+		}
+		output = append(output, data[i])
+		rewriter.previous = data[i]
+		i++
+	}
+	rewriter.pending = append(rewriter.pending, tail...)
+
+	if len(output) == 0 {
+		return nil
+	}
+	_, err := rewriter.writer.Write(output)
+	return err
+}
+
+// match returns the mapping whose container path starts data. partial is true if more data is required to decide.
+func (rewriter *pathRewriter) match(data []byte, final bool) (result *pathMapping, partial bool) {
+	for i := range rewriter.mappings {
+		container := rewriter.mappings[i].container
+		if len(data) <= len(container) {
+			if !final && string(data) == container[:len(data)] {
+				// We need at least one more byte to check that the path component ends here
+				return nil, true
+			}
+			if final && string(data) == container && result == nil {
+				result = &rewriter.mappings[i]
+			}
+			continue
+		}
+		if result == nil && string(data[:len(container)]) == container && (data[len(container)] == '/' || !isPathByte(data[len(container)])) {
+			result = &rewriter.mappings[i]
+		}
+	}
+	return result, false
+}
+
+func nextEscapeState(state escapeState, b byte) escapeState {
+	switch {
+	case state == escapeNone && b == escapeChar:
+		return escapeStart
+	case state == escapeStart && b == '[':
+		return escapeCSI
+	case state == escapeStart && (b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_'):
+		// Operating system command, device control string... terminated by BEL or ESC \
+		return escapeString
+	case state == escapeCSI && (b < 0x40 || b > 0x7e):
+		// Parameter and intermediate bytes of a control sequence
+		return escapeCSI
+	case state == escapeString && b == escapeChar:
+		// ESC \ terminates the string, the backslash then ends the escape sequence
+		return escapeStart
+	case state == escapeString && b != bellChar:
+		return escapeString
+	}
+	return escapeNone
+}
+
+// getIncompleteRuneLength returns the number of bytes ending data that start a multi-byte character without
+// completing it.
+func getIncompleteRuneLength(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return 0
+			}
+			return len(data) - i
+		}
+	}
+	return 0
+}
+
+func isPathByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '-' || b == '.' || b == '/'
+}
+
+// argEscape prefixes an argument that must not be translated by translateArgs. The prefix is always removed, so an
+// argument that really starts with \ must be given with two backslashes.
+const argEscape = `\`
+
+// translateArgs replaces the arguments that refer to host files located in a mounted folder by their path inside the
+// container. Paths must be absolute or start with ./ or ../ and the file (or its parent folder) must exist.
+func translateArgs(args []string, cwd string, mappings []pathMapping) []string {
+	sorted := append([]pathMapping(nil), mappings...)
+	// Longest paths first so the most specific mount is used
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].host) > len(sorted[j].host) })
+
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = translateArg(arg, cwd, sorted)
+	}
+	return result
+}
+
+func translateArg(arg, cwd string, mappings []pathMapping) string {
+	if strings.HasPrefix(arg, argEscape) {
+		return strings.TrimPrefix(arg, argEscape)
+	}
+
+	if strings.HasPrefix(arg, "-") {
+		// Handle options such as --var-file=./file.tfvars
+		if index := strings.Index(arg, "="); index > 0 {
+			if translated, ok := translatePath(arg[index+1:], cwd, mappings); ok {
+				return arg[:index+1] + translated
+			}
+		}
+		return arg
+	}
+
+	if translated, ok := translatePath(arg, cwd, mappings); ok {
+		return translated
+	}
+	return arg
+}
+
+func translatePath(value, cwd string, mappings []pathMapping) (string, bool) {
+	slashValue := filepath.ToSlash(value)
+	if !filepath.IsAbs(value) && slashValue != "." && slashValue != ".." && !strings.HasPrefix(slashValue, "./") && !strings.HasPrefix(slashValue, "../") {
+		return "", false
+	}
+
+	hostPath := value
+	if !filepath.IsAbs(hostPath) {
+		hostPath = filepath.Join(cwd, hostPath)
+	}
+	hostPath = filepath.Clean(hostPath)
+
+	// The path may not exist yet (i.e. an output file), but its folder must
+	folder := filepath.Dir(hostPath)
+	if _, err := os.Stat(hostPath); err != nil {
+		if _, err := os.Stat(folder); err != nil {
+			return "", false
+		}
+	}
+	if folder, err := filepath.EvalSymlinks(folder); err == nil {
+		hostPath = filepath.Join(folder, filepath.Base(hostPath))
+	}
+	hostPath = filepath.ToSlash(hostPath)
+
+	for _, mapping := range mappings {
+		host := strings.TrimSuffix(mapping.host, "/")
+		if hostPath != host && !strings.HasPrefix(hostPath, host+"/") {
+			continue
+		}
+		translated := path.Join(mapping.container, strings.TrimPrefix(hostPath, host))
+		if strings.HasSuffix(slashValue, "/") && !strings.HasSuffix(translated, "/") {
+			translated += "/"
+		}
+		return translated, true
+	}
+	return "", false
+}
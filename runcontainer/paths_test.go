@@ -0,0 +1,139 @@
+package runcontainer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestContainsPath(t *testing.T) {
+	tests := []struct {
+		folder, path string
+		want         bool
+	}{
+		{"/home", "/home/jdoe", true},
+		{"/home/jdoe/", "/home/jdoe", true},
+		{"/home/jdoe/src/project", "/home/jdoe", false},
+		{"/home/jdoe2", "/home/jdoe", false},
+		{"/current_sources", "/home/jdoe", false},
+	}
+	for _, tt := range tests {
+		if got := containsPath(tt.folder, tt.path); got != tt.want {
+			t.Errorf("containsPath(%s, %s) = %v, want %v", tt.folder, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathRewriter(t *testing.T) {
+	mappings := []pathMapping{{host: "/home/jdoe/src", container: "/current_sources"}, {host: "/tmp/cache", container: "/var/runcontainer"}}
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"path", []string{"Error in /current_sources/main.tf\n"}, "Error in /home/jdoe/src/main.tf\n"},
+		{"not a whole component", []string{"/current_sources2/main.tf /data/current_sources\n"}, "/current_sources2/main.tf /data/current_sources\n"},
+		{"split path", []string{"Error in /cur", "rent_sou", "rces/main.tf\n"}, "Error in /home/jdoe/src/main.tf\n"},
+		{"path at the end", []string{"cd /current_sources"}, "cd /home/jdoe/src"},
+		{"split character", []string{"\xc3", "\xa9 /var/runcontainer\n"}, "\xc3\xa9 /tmp/cache\n"},
+		{"color", []string{"\x1b[31m/current_sources\x1b[0m\n"}, "\x1b[31m/home/jdoe/src\x1b[0m\n"},
+		{"title", []string{"\x1b]0;/current_sources\x07/current_sources\n"}, "\x1b]0;/current_sources\x07/home/jdoe/src\n"},
+		{"hyperlink", []string{"\x1b]8;;file:///current_sources/a\x1b\\", "/current_sources/a\x1b]8;;\x1b\\\n"}, "\x1b]8;;file:///current_sources/a\x1b\\/home/jdoe/src/a\x1b]8;;\x1b\\\n"},
+		{"binary", []string{"\xff\x00/current_sources", "/current_sources\n"}, "\xff\x00/current_sources/current_sources\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			rewriter := newPathRewriter(&output, mappings)
+			rewriter.delay = 0
+			for _, chunk := range tt.chunks {
+				if n, err := rewriter.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			if err := rewriter.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != tt.want {
+				t.Errorf("output = %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPathRewriterDelay(t *testing.T) {
+	var output lockedBuffer
+	rewriter := newPathRewriter(&output, []pathMapping{{host: "/home/jdoe/src", container: "/current_sources"}})
+	rewriter.delay = time.Millisecond
+
+	// A prompt ending with the beginning of a mount path is written without waiting for more output
+	rewriter.Write([]byte("Path [/cur"))
+	deadline := time.Now().Add(5 * time.Second)
+	for output.String() != "Path [/cur" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if output.String() != "Path [/cur" {
+		t.Errorf("output = %q, want the pending bytes", output.String())
+	}
+}
+
+// lockedBuffer is a buffer that can be read while another goroutine writes it.
+type lockedBuffer struct {
+	buffer bytes.Buffer
+	lock   sync.Mutex
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	return buffer.buffer.String()
+}
+
+func TestTranslateArgs(t *testing.T) {
+	folder := t.TempDir()
+	workspace := filepath.Join(folder, "workspace")
+	cwd := filepath.Join(workspace, "project")
+	if err := os.MkdirAll(filepath.Join(cwd, "modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cwd, "main.tf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if workspace, err := filepath.EvalSymlinks(workspace); err == nil {
+		folder = filepath.Dir(workspace)
+	}
+	mappings := []pathMapping{{host: filepath.ToSlash(filepath.Join(folder, "workspace")), container: "/current_sources"}}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"plan", "plan"},
+		{"./main.tf", "/current_sources/project/main.tf"},
+		{"./modules/", "/current_sources/project/modules/"},
+		{"./plan.out", "/current_sources/project/plan.out"},
+		{"..", "/current_sources"},
+		{"--var-file=./main.tf", "--var-file=/current_sources/project/main.tf"},
+		{"-input=false", "-input=false"},
+		{"./missing/file", "./missing/file"},
+		{"../../outside", "../../outside"},
+		{`\./main.tf`, "./main.tf"},
+		{`\plan`, "plan"},
+		{`\\server`, `\server`},
+	}
+	for _, tt := range tests {
+		if got := translateArgs([]string{tt.arg}, cwd, mappings)[0]; got != tt.want {
+			t.Errorf("translateArgs(%s) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package runcontainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the executables exposed as runcontainer subcommands.
+const PluginPrefix = "runcontainer-"
+
+// Plugin is an executable exposed as a runcontainer subcommand.
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// DefaultPluginFolder returns the folder searched for plugins before the PATH.
+func DefaultPluginFolder() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".runcontainer", "plugins"), nil
+}
+
+// FindPlugin returns the plugin of a subcommand, or nil if there is none. The plugin folder has precedence over the
+// PATH.
+func FindPlugin(folder, name string) *Plugin {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil
+	}
+	if folder != "" {
+		if path, err := exec.LookPath(filepath.Join(folder, PluginPrefix+name)); err == nil {
+			return &Plugin{Name: name, Path: path}
+		}
+	}
+	if path, err := exec.LookPath(PluginPrefix + name); err == nil {
+		return &Plugin{Name: name, Path: path}
+	}
+	return nil
+}
+
+// ListPlugins returns the plugins found in the plugin folder and the PATH, sorted by name. A plugin hidden by another
+// one with the same name is not returned.
+func ListPlugins(folder string) []Plugin {
+	plugins := map[string]Plugin{}
+	for _, directory := range append([]string{folder}, filepath.SplitList(os.Getenv("PATH"))...) {
+		if directory == "" {
+			continue
+		}
+		files, err := ioutil.ReadDir(directory)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := getPluginName(file)
+			if _, found := plugins[name]; found || name == "" {
+				continue
+			}
+			plugins[name] = Plugin{Name: name, Path: filepath.Join(directory, file.Name())}
+		}
+	}
+
+	result := make([]Plugin, 0, len(plugins))
+	for _, plugin := range plugins {
+		result = append(result, plugin)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Run runs the plugin with the arguments and returns its exit code. The profile, if any, is serialized as JSON in
+// RUNCONTAINER_PROFILE_JSON so plugins reuse the configuration resolved by runcontainer.
+func (plugin *Plugin) Run(config *DockerConfig, streams Streams, args ...string) (int, error) {
+	environ := os.Environ()
+	if executable, err := os.Executable(); err == nil {
+		environ = append(environ, "RUNCONTAINER_EXECUTABLE="+executable)
+	}
+	if config != nil {
+		profile, err := json.Marshal(config)
+		if err != nil {
+			return 1, err
+		}
+		environ = append(environ,
+			"RUNCONTAINER_PROFILE="+config.Environment["RUNCONTAINER_PROFILE"],
+			"RUNCONTAINER_CONFIGURATIONFILENAME="+config.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"],
+			"RUNCONTAINER_PROFILE_JSON="+string(profile),
+		)
+	}
+
+	cmd := exec.Command(plugin.Path, args...)
+	cmd.Env = environ
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = streams.In, streams.Out, streams.Err
+	if err := cmd.Run(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return exitError.ExitCode(), nil
+		}
+		return 1, err
+	}
+	return 0, nil
+}
+
+func getPluginName(file os.FileInfo) string {
+	if file.IsDir() || !strings.HasPrefix(file.Name(), PluginPrefix) {
+		return ""
+	}
+	name := strings.TrimPrefix(file.Name(), PluginPrefix)
+	if runtime.GOOS == "windows" {
+		extension := strings.ToLower(filepath.Ext(name))
+		if extension != ".exe" && extension != ".cmd" && extension != ".bat" {
+			return ""
+		}
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if file.Mode()&0111 == 0 {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,77 @@
+package runcontainer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFindPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are batch files on Windows")
+	}
+	folder, path := t.TempDir(), t.TempDir()
+	for fileName, mode := range map[string]os.FileMode{
+		filepath.Join(folder, "runcontainer-lint"): 0755,
+		filepath.Join(path, "runcontainer-lint"):   0755,
+		filepath.Join(path, "runcontainer-docs"):   0755,
+		filepath.Join(path, "runcontainer-notes"):  0644,
+	} {
+		if err := ioutil.WriteFile(fileName, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", path)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"lint", filepath.Join(folder, "runcontainer-lint")},
+		{"docs", filepath.Join(path, "runcontainer-docs")},
+		{"notes", ""},
+		{"missing", ""},
+		{"../runcontainer-docs", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if plugin := FindPlugin(folder, tt.name); plugin != nil {
+				got = plugin.Path
+			}
+			if got != tt.want {
+				t.Errorf("FindPlugin(%s) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are batch files on Windows")
+	}
+	fileName := filepath.Join(t.TempDir(), "runcontainer-check")
+	script := "#!/bin/sh\necho \"$RUNCONTAINER_PROFILE $*\"\nexit 3\n"
+	if err := ioutil.WriteFile(fileName, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	plugin := &Plugin{Name: "check", Path: fileName}
+	config := &DockerConfig{Image: "alpine", Environment: map[string]string{"RUNCONTAINER_PROFILE": "default"}}
+	exitCode, err := plugin.Run(config, Streams{In: strings.NewReader(""), Out: &stdout, Err: &stdout}, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 3 {
+		t.Errorf("exit code = %d, want 3", exitCode)
+	}
+	if want := "default a b\n"; stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package runcontainer
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group, which contains the processes it starts. When
+// the runner is the foreground process group of the terminal used as input of the command, the new group becomes the
+// foreground group so an interactive command (e.g. a login prompt) can read the terminal instead of being stopped.
+// The returned function gives the terminal back to the runner once the command completed.
+func setProcessGroup(cmd *exec.Cmd) (restoreTerminal func()) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	restoreTerminal = func() {}
+
+	terminal, ok := cmd.Stdin.(*os.File)
+	if !ok || !IsTerminal(terminal) {
+		return
+	}
+	fd := int(terminal.Fd())
+	if group, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP); err != nil || group != syscall.Getpgrp() {
+		return
+	}
+
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = fd
+	return func() {
+		// The runner is a background process once the command took the terminal, it would be stopped by SIGTTOU
+		// when taking it back
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+		unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, syscall.Getpgrp())
+	}
+}
+
+// killProcessGroup kills the process group of a command started with setProcessGroup.
+func killProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package runcontainer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunnerTimeoutKillsHookProcesses(t *testing.T) {
+	run := newTestRun(t, WithTimeout(300*time.Millisecond))
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		if command.Script != "" {
+			return execExecutor{}.Run(ctx, command)
+		}
+		return nil
+	}
+	pidFile := filepath.Join(run.folder, "child.pid")
+	profile := run.profile()
+	profile.TempDirMountLocation = MountLocNone
+	profile.Hooks = &Hooks{Before: []string{fmt.Sprintf("sleep 30 & echo $! > %s; wait", pidFile)}}
+
+	start := time.Now()
+	run.runner.Run(context.Background(), profile, []string{"true"})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("the hook was not stopped at the timeout, the run took %v", elapsed)
+	}
+
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); isProcessRunning(pid); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("the process %d started by the hook is still running", pid)
+		}
+	}
+}
+
+// isProcessRunning returns true if the process exists and is not a zombie waiting to be reaped.
+func isProcessRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
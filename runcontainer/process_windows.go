@@ -0,0 +1,24 @@
+package runcontainer
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a new process group. The console stays usable by the command, there is
+// nothing to restore once it completed.
+func setProcessGroup(cmd *exec.Cmd) (restoreTerminal func()) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	return func() {}
+}
+
+// killProcessGroup kills the process of a command and the processes it started.
+func killProcessGroup(process *os.Process) error {
+	// Windows has no process groups that can be killed at once, taskkill walks the process tree
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid)).Run(); err != nil {
+		return process.Kill()
+	}
+	return nil
+}
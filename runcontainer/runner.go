@@ -0,0 +1,868 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner runs profiles in containers. It has no side effect on the current process (environment, working directory
+// or signals) so several profiles can be run concurrently by the same runner.
+type Runner struct {
+	client client.APIClient
+	// clientSet is true if the client is set by WithClient, it is then used for all the daemons
+	clientSet bool
+	// clients are the clients of the daemons selected by the profiles, by resolved endpoint
+	clients     map[dockerEndpoint]client.APIClient
+	clientsLock sync.Mutex
+	streams     Streams
+	environ     []string
+	clock       Clock
+	workingDir  string
+	executor    Executor
+	logger      logrus.FieldLogger
+	audit       *AuditLog
+	// resultHandlers are called with the result of every run
+	resultHandlers []func(Result)
+	user           *user.User
+	// timeout overrides the timeout of the profiles
+	timeout     time.Duration
+	lockTimeout time.Duration
+	// lockFolder contains the lock files and volumeUsesFile records the last use of the volumes, they are only
+	// changed by the tests
+	lockFolder     string
+	volumeUsesFile string
+	// volumePrefixes identify the volumes managed by the runner and volumeProfile selects their daemon
+	volumePrefixes []string
+	volumeProfile  *DockerConfig
+	// dockerSocket is the socket mounted by with-docker-mount instead of the socket of the daemon, only set by the tests
+	dockerSocket string
+	// workspaceRoot is the host folder mounted instead of the top level folder of the current directory, it is only
+	// set by the tests
+	workspaceRoot string
+}
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// Clock provides the current time to the runner.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimeoutExitCode is the exit code of a run that timed out.
+const TimeoutExitCode = 124
+
+const defaultStopGracePeriod = 10 * time.Second
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Result is the outcome of a run.
+type Result struct {
+	// Profile, Image and Run (the task or matrix run) identify the run
+	Profile string
+	Image   string
+	Run     string
+
+	ExitCode   int
+	TimedOut   bool
+	Started    time.Time
+	Finished   time.Time
+	Timings    Timings
+	DockerArgs []string
+}
+
+// Duration returns the time spent running the container and the hooks.
+func (result Result) Duration() time.Duration {
+	return result.Finished.Sub(result.Started)
+}
+
+// WithClient sets the docker client used to inspect the images, whatever the daemon selected by the profiles. A client
+// connected to the daemon of the profile is created when needed otherwise.
+func WithClient(client client.APIClient) Option {
+	return func(runner *Runner) { runner.client, runner.clientSet = client, true }
+}
+
+// WithStreams sets the streams of the container and the hooks (default is the standard streams).
+func WithStreams(streams Streams) Option {
+	return func(runner *Runner) { runner.streams = streams }
+}
+
+// WithEnvironment sets the host environment given to docker, the hooks and the container (default is the current
+// process environment).
+func WithEnvironment(environ []string) Option {
+	return func(runner *Runner) { runner.environ = append([]string{}, environ...) }
+}
+
+// WithClock sets the clock used to time the runs.
+func WithClock(clock Clock) Option {
+	return func(runner *Runner) { runner.clock = clock }
+}
+
+// WithExecutor sets the executor running docker and the hooks.
+func WithExecutor(executor Executor) Option {
+	return func(runner *Runner) { runner.executor = executor }
+}
+
+// WithLogger sets the logger receiving the details of the runs (default is the logrus standard logger).
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(runner *Runner) { runner.logger = logger }
+}
+
+// WithAuditLog records the runs in the audit log.
+func WithAuditLog(log *AuditLog) Option {
+	return func(runner *Runner) { runner.audit = log }
+}
+
+// WithResultHandler calls the handler with the result of every run (i.e. to report its timings).
+func WithResultHandler(handler func(Result)) Option {
+	return func(runner *Runner) { runner.resultHandlers = append(runner.resultHandlers, handler) }
+}
+
+// WithUser sets the host user running the container (default is the current user).
+func WithUser(user *user.User) Option {
+	return func(runner *Runner) { runner.user = user }
+}
+
+// WithWorkingDirectory sets the host folder the command is run from (default is the current directory).
+func WithWorkingDirectory(folder string) Option {
+	return func(runner *Runner) { runner.workingDir = folder }
+}
+
+// WithTimeout sets the maximum duration of the runs, overriding the timeout of the profiles.
+func WithTimeout(timeout time.Duration) Option {
+	return func(runner *Runner) { runner.timeout = timeout }
+}
+
+// WithLockTimeout sets the maximum time waiting for the lock of a profile (default is to wait until the context is
+// done).
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(runner *Runner) { runner.lockTimeout = timeout }
+}
+
+// WithVolumePrefixes sets the prefixes of the volumes managed by the runner (default is the default prefix of the
+// profiles), see DockerConfigs.VolumePrefixes.
+func WithVolumePrefixes(prefixes ...string) Option {
+	return func(runner *Runner) { runner.volumePrefixes = prefixes }
+}
+
+// WithVolumeProfile makes the runner manage the volumes of the docker daemon selected by the profile (docker-host,
+// docker-context...) instead of the daemon configured by the environment.
+func WithVolumeProfile(profile *DockerConfig) Option {
+	return func(runner *Runner) { runner.volumeProfile = profile }
+}
+
+// NewRunner returns a runner configured with the options.
+func NewRunner(options ...Option) *Runner {
+	runner := &Runner{streams: StandardStreams(), clock: systemClock{}, executor: execExecutor{}, logger: logrus.StandardLogger()}
+	for _, option := range options {
+		option(runner)
+	}
+	return runner
+}
+
+// Run runs the profile container with the args, or with its entry point if there are none. The profile is not
+// modified. A non zero exit code of the container is reported in the result, the error is only set if the container
+// could not be run. Cancelling the context, or reaching the timeout, stops the container and its services. Runs are
+// recorded in the audit log, including the ones failing before their container could be run (with exit code 1).
+func (runner *Runner) Run(ctx context.Context, profile *DockerConfig, args []string) (result Result, err error) {
+	result = Result{Started: runner.clock.Now(), Image: profile.GetImageName()}
+	config := profile.clone()
+	result.Profile = config.Environment["RUNCONTAINER_PROFILE"]
+	result.Run = config.Environment["RUNCONTAINER_MATRIX_RUN"]
+	if result.Run == "" {
+		result.Run = config.Environment["RUNCONTAINER_TASK"]
+	}
+	defer func() {
+		if err != nil && runner.audit != nil {
+			runner.recordFailure(ctx, config, result, args)
+		}
+	}()
+
+	timeout, err := parseDuration(config.Timeout, 0)
+	if err != nil {
+		return result, fmt.Errorf("invalid timeout: %v", err)
+	}
+	if runner.timeout > 0 {
+		timeout = runner.timeout
+	}
+	gracePeriod, err := parseDuration(config.StopGracePeriod, defaultStopGracePeriod)
+	if err != nil {
+		return result, fmt.Errorf("invalid stop-grace-period: %v", err)
+	}
+	endpoint, err := config.getDockerEndpoint()
+	if err != nil {
+		return result, err
+	}
+	security, err := config.getSecurity()
+	if err != nil {
+		return result, err
+	}
+	// The docker commands and the API client use the daemon of the profile
+	executor := Executor(dockerExecutor{Executor: runner.executor, options: endpoint.getCLIOptions()})
+	cli, err := runner.getClient(endpoint, config.getHostEnvironment(runner.getEnvironment()))
+	if err != nil {
+		return result, err
+	}
+
+	copied, err := config.isWorkspaceCopied()
+	if err != nil {
+		return result, err
+	}
+
+	cwd, err := runner.getWorkingDirectory()
+	if err != nil {
+		return result, err
+	}
+
+	hostFolder, mountFolder, sourceFolder, err := config.getSourceMount(cwd, runner.workspaceRoot)
+	if err != nil {
+		return result, err
+	}
+
+	imageName := config.GetImageName()
+	pathMappings := addPathMapping(nil, hostFolder, mountFolder)
+	streams := runner.streams
+	logger := runner.logger.WithField("image", imageName)
+	logger.Debugf("Mounting workspace %s at %s, working folder is %s", hostFolder, mountFolder, sourceFolder)
+
+	if config.Lock != "" && config.Lock != LockNone {
+		start := runner.clock.Now()
+		release, err := runner.lock(ctx, config.Lock, hostFolder, result.Profile)
+		result.Timings.Add(PhaseLock, runner.clock.Now().Sub(start))
+		if err != nil {
+			return result, err
+		}
+		defer release()
+	}
+
+	dockerArgs := []string{
+		"create",
+	}
+	if config.DockerInteractive {
+		// A terminal is only allocated when there is one, so piped input and output keep working (i.e. from shims)
+		if IsTerminal(streams.In) && IsTerminal(streams.Out) {
+			dockerArgs = append(dockerArgs, "-it")
+		} else {
+			dockerArgs = append(dockerArgs, "-i")
+		}
+	}
+	var transfer *workspaceTransfer
+	if copied {
+		logger.Debugf("Copying workspace %s to %s (%s)", hostFolder, mountFolder, config.WorkspaceTransfer)
+		if transfer, err = newWorkspaceTransfer(config.WorkspaceTransfer, hostFolder, mountFolder); err != nil {
+			return result, err
+		}
+	} else {
+		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s", convertDrive(hostFolder), mountFolder))
+	}
+	dockerArgs = append(dockerArgs, "-w", sourceFolder)
+
+	if config.WithDockerMount {
+		socket, err := runner.getDockerSocket(endpoint, config.getHostEnvironment(runner.getEnvironment()))
+		if err != nil {
+			return result, err
+		}
+		logger.Debugf("Mounting docker socket %s", socket)
+		withDockerMountArgs, err := getDockerMountArgs(socket)
+		if err != nil {
+			return result, err
+		}
+		dockerArgs = append(dockerArgs, withDockerMountArgs...)
+	}
+
+	currentUser, err := runner.getUser()
+	if err != nil {
+		return result, err
+	}
+
+	// No need to map to current user on windows. Files written by docker containers in windows seem to be accessible by the user calling docker
+	if config.WithCurrentUser && runtime.GOOS != "windows" {
+		logger.Debugf("Running as user %s:%s", currentUser.Uid, currentUser.Gid)
+		dockerArgs = append(dockerArgs, fmt.Sprintf("--user=%s:%s", currentUser.Uid, currentUser.Gid))
+	}
+
+	// volumes are the docker volumes mounted by the run
+	var volumes []string
+	var homeMounted bool
+	if config.MountHomeDirectory {
+		home := filepath.ToSlash(currentUser.HomeDir)
+		mountingHome := fmt.Sprintf("/home/%s", filepath.Base(home))
+		logger.Debugf("Mounting home directory %s at %s", home, mountingHome)
+
+		dockerArgs = append(dockerArgs, []string{
+			"-v", fmt.Sprintf("%v:%v", convertDrive(home), mountingHome),
+			"-e", fmt.Sprintf("HOME=%v", mountingHome),
+		}...)
+		pathMappings = addPathMapping(pathMappings, home, mountingHome)
+		homeMounted = true
+	} else if config.TempDirMountLocation != MountLocNone {
+		// If temp location is not disabled, we persist the home folder in a docker volume
+		start := runner.clock.Now()
+		username, err := runner.getImageUsername(ctx, cli, imageName, currentUser.Username)
+		result.Timings.Add(PhaseImage, runner.clock.Now().Sub(start))
+		if err != nil {
+			return result, err
+		}
+
+		homePath := fmt.Sprintf("/home/%s", username)
+		homeVolume, err := config.getHomeVolumeName(username, cwd)
+		if err != nil {
+			return result, err
+		}
+		if containsPath(mountFolder, homePath) {
+			// The volume would hide the workspace (or a part of it), e.g. when the home directory is mirrored
+			logger.Warnf("Not persisting home %s in volume %s, it is inside the workspace mounted at %s", homePath, homeVolume, mountFolder)
+			dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("HOME=%s", homePath))
+		} else {
+			logger.Debugf("Persisting home %s in volume %s", homePath, homeVolume)
+			dockerArgs = append(dockerArgs,
+				"-e", fmt.Sprintf("HOME=%s", homePath),
+				"-v", fmt.Sprintf("%s:%s", homeVolume, homePath),
+			)
+			volumes = append(volumes, homeVolume)
+		}
+		homeMounted = true
+	}
+
+	if security != nil {
+		tmpfs := []string{"/tmp"}
+		if security.ReadOnlyRootfs && !homeMounted {
+			// The home must be writable for most tools, it is discarded with the container
+			start := runner.clock.Now()
+			username, err := runner.getImageUsername(ctx, cli, imageName, currentUser.Username)
+			result.Timings.Add(PhaseImage, runner.clock.Now().Sub(start))
+			if err != nil {
+				return result, err
+			}
+			homePath := fmt.Sprintf("/home/%s", username)
+			dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("HOME=%s", homePath))
+			tmpfs = append(tmpfs, homePath)
+		}
+		logger.Debugf("Applying security settings %+v", *security)
+		dockerArgs = append(dockerArgs, security.getDockerArgs(tmpfs...)...)
+	}
+
+	dockerArgs = append(dockerArgs, config.DockerOptions...)
+	if config.StopSignal != "" {
+		dockerArgs = append(dockerArgs, "--stop-signal", config.StopSignal)
+	}
+
+	switch config.TempDirMountLocation {
+	case MountLocHost:
+		tempDir, err := filepath.EvalSymlinks(os.TempDir())
+		if err != nil {
+			return result, err
+		}
+
+		temp := filepath.ToSlash(filepath.Join(tempDir, "runcontainer-cache"))
+		tempDrive := fmt.Sprintf("%s/", filepath.VolumeName(temp))
+		tempFolder := strings.TrimPrefix(temp, tempDrive)
+		if runtime.GOOS == "windows" {
+			os.Mkdir(temp, 0755)
+		}
+		tempMountPath := dockerMountImagePath
+		if config.MirrorTempPath {
+			tempMountPath = getMirrorPath(temp)
+		}
+		logger.Debugf("Mounting temp folder %s at %s", temp, tempMountPath)
+		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s%s:%s", convertDrive(tempDrive), tempFolder, tempMountPath))
+		config.Environment["RUNCONTAINER_TEMP_FOLDER"] = path.Join(tempDrive, tempFolder)
+		pathMappings = addPathMapping(pathMappings, temp, tempMountPath)
+	case MountLocNone:
+		logger.Debug("Temp folder is not mounted")
+	case MountLocVolume:
+		// docker's -v option will automatically create the volume if it doesn't already exist
+		tempVolume, err := config.getVolumePrefix()
+		if err != nil {
+			return result, err
+		}
+		logger.Debugf("Mounting volume %s at %s", tempVolume, dockerMountImagePath)
+		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s", tempVolume, dockerMountImagePath))
+		volumes = append(volumes, tempVolume)
+	default:
+		return result, fmt.Errorf("unknown temp-dir-mount-location '%s'", config.TempDirMountLocation)
+	}
+
+	if len(config.Caches) > 0 {
+		prefix, err := config.getVolumePrefix()
+		if err != nil {
+			return result, err
+		}
+		var owner string
+		if config.WithCurrentUser && runtime.GOOS != "windows" {
+			owner = fmt.Sprintf("%s:%s", currentUser.Uid, currentUser.Gid)
+		}
+		for _, cache := range config.Caches {
+			cache, err := cache.resolve()
+			if err != nil {
+				return result, err
+			}
+			volume := cache.getVolumeName(prefix, config.Image)
+			if err := runner.createCacheVolume(ctx, executor, cache, volume, owner); err != nil {
+				return result, err
+			}
+			logger.Debugf("Mounting cache %s at %s", volume, cache.Target)
+			dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s", volume, cache.Target))
+			if _, isSet := config.Environment[cache.Env]; cache.Env != "" && !isSet {
+				config.Environment[cache.Env] = cache.Target
+			}
+			volumes = append(volumes, volume)
+		}
+	}
+
+	command := strings.Split(config.EntryPoint, " ")
+	if len(args) > 0 {
+		command = args
+		if config.TranslateArgs {
+			command = translateArgs(args, cwd, pathMappings)
+		}
+	}
+
+	auditArgs := command
+	config.Environment["RUNCONTAINER_COMMAND"] = strings.Join(command, " ")
+	config.Environment["RUNCONTAINER_VERSION"] = version
+	config.Environment["RUNCONTAINER_ARGS"] = strings.Join(args, " ")
+	config.Environment["RUNCONTAINER_LAUNCH_FOLDER"] = sourceFolder
+	config.Environment["RUNCONTAINER_IMAGE_NAME"] = imageName // sha256 of image
+	config.Environment["RUNCONTAINER_IMAGE"] = config.Image
+	if config.ImageTag != "" {
+		config.Environment["RUNCONTAINER_IMAGE_TAG"] = config.ImageTag
+	}
+
+	for _, do := range config.DockerOptions {
+		dockerArgs = append(dockerArgs, strings.Split(do, " ")...)
+	}
+
+	runID := newRunID()
+	var services *serviceRun
+	if len(config.Services) > 0 {
+		services = &serviceRun{network: runID, executor: executor, logger: logger}
+		dockerArgs = append(dockerArgs, "--network", services.network)
+		config.Environment["RUNCONTAINER_NETWORK"] = services.network
+	}
+
+	// The container is named so it can be started and stopped. We do not remove the container after execution if a
+	// name has been provided.
+	containerName := getOptionValue(dockerArgs, "--name")
+	removeContainer := containerName == ""
+	if removeContainer {
+		containerName = runID
+		dockerArgs = append(dockerArgs, "--name", containerName)
+	}
+
+	if initCommands := config.getHookCommands(HookContainerInit); len(initCommands) > 0 {
+		// The entry point is replaced by the init script, which must then run the original one
+		entrypoint := []string{getOptionValue(dockerArgs, "--entrypoint")}
+		if entrypoint[0] == "" {
+			if entrypoint, err = runner.getImageEntrypoint(ctx, cli, executor, imageName); err != nil {
+				return result, err
+			}
+		}
+		dockerArgs = append(dockerArgs, "--entrypoint", "/bin/sh")
+		command = append([]string{"-c", getContainerInitScript(initCommands, entrypoint), "runcontainer-init"}, command...)
+	}
+
+	// The profile environment is given to docker and to the hooks without altering the current process environment
+	environ := config.getHostEnvironment(runner.getEnvironment())
+	dockerArgs = append(dockerArgs, getEnviron(environ, config.MountHomeDirectory)...)
+	dockerArgs = append(dockerArgs, imageName)
+	dockerArgs = append(dockerArgs, command...)
+	result.DockerArgs = dockerArgs
+
+	var stderr bytes.Buffer
+	startArgs := []string{"start", "-a"}
+	if config.DockerInteractive {
+		startArgs = append(startArgs, "-i")
+	}
+	startCommand := Command{Name: "docker", Args: append(startArgs, containerName), Env: environ, Dir: cwd, Stdin: streams.In, Stdout: streams.Out, Stderr: &stderr}
+
+	var rewriters []*pathRewriter
+	if config.RewriteOutputPaths {
+		rewriters = []*pathRewriter{newPathRewriter(streams.Out, pathMappings), newPathRewriter(&stderr, pathMappings)}
+		startCommand.Stdout, startCommand.Stderr = rewriters[0], rewriters[1]
+	}
+
+	// The deadline covers the hooks, the services and the container
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		logger.Debugf("Run times out after %v", timeout)
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	finish := func(exitCode int) (Result, error) {
+		hooksCtx := runCtx
+		if exitCode != 0 && runCtx.Err() == context.DeadlineExceeded {
+			fmt.Fprintf(streams.Err, "Timed out after %v\n", timeout)
+			result.TimedOut = true
+			exitCode = TimeoutExitCode
+		}
+		if runCtx.Err() != nil {
+			// The failure hooks are given the grace period to clean up after a run that was interrupted
+			var cancel context.CancelFunc
+			hooksCtx, cancel = context.WithTimeout(context.Background(), gracePeriod)
+			defer cancel()
+		}
+
+		start := runner.clock.Now()
+		result.ExitCode = runner.runFinalHooks(hooksCtx, config, environ, cwd, exitCode)
+		result.Timings.Add(PhaseHooks, runner.clock.Now().Sub(start))
+		logger.Debugf("Exit code is %d", result.ExitCode)
+		result.Finished = runner.clock.Now()
+		if runner.audit != nil {
+			runner.recordRun(ctx, cli, config, result, currentUser.Username, cwd, auditArgs)
+		}
+		for _, handler := range runner.resultHandlers {
+			handler(result)
+		}
+		return result, nil
+	}
+
+	start := runner.clock.Now()
+	err = runner.runHooks(runCtx, config, HookBefore, environ, cwd, 0)
+	result.Timings.Add(PhaseHooks, runner.clock.Now().Sub(start))
+	if err != nil {
+		fmt.Fprintln(streams.Err, err)
+		return finish(1)
+	}
+	if services != nil {
+		start := runner.clock.Now()
+		err := services.start(runCtx, config.Services)
+		result.Timings.Add(PhaseServices, runner.clock.Now().Sub(start))
+		defer func() {
+			start := runner.clock.Now()
+			services.stop(streams.Err)
+			result.Timings.Add(PhaseCleanup, runner.clock.Now().Sub(start))
+		}()
+		if err != nil {
+			fmt.Fprintln(streams.Err, err)
+			return finish(1)
+		}
+	}
+
+	// The container is created, then started, so the time spent by docker to prepare it is distinguished from the
+	// time spent by the command itself
+	createCommand := Command{Name: "docker", Args: dockerArgs, Env: environ, Dir: cwd, Stdout: ioutil.Discard, Stderr: streams.Err}
+	logger.Debugf("Running %s", createCommand)
+	start = runner.clock.Now()
+	err = executor.Run(runCtx, createCommand)
+	result.Timings.Add(PhaseCreate, runner.clock.Now().Sub(start))
+	if err != nil {
+		fmt.Fprintf(streams.Err, "%v\n%s\n", err, createCommand)
+		if runtime.GOOS == "windows" {
+			fmt.Fprint(streams.Err, windowsMessage)
+		}
+		return finish(1)
+	}
+	runner.recordVolumeUse(volumes...)
+
+	remove := func() {
+		if removeContainer {
+			if _, err := executeDocker(context.Background(), executor, "rm", "-f", "-v", containerName); err != nil {
+				logger.Warningf("Unable to remove container %s: %v", containerName, err)
+			}
+		}
+	}
+	if transfer != nil {
+		start := runner.clock.Now()
+		err := transfer.upload(runCtx, cli, containerName)
+		result.Timings.Add(PhaseTransfer, runner.clock.Now().Sub(start))
+		if err != nil {
+			fmt.Fprintf(streams.Err, "Unable to copy the workspace to the container: %v\n", err)
+			remove()
+			return finish(1)
+		}
+	}
+
+	// docker start is not killed when the run is interrupted, the container is stopped instead: docker sends the stop
+	// signal, waits for the grace period then kills the container and docker start returns
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-runCtx.Done():
+			logger.Debugf("Stopping container %s", containerName)
+			stopTime := int((gracePeriod + time.Second - 1) / time.Second)
+			if _, err := executeDocker(context.Background(), executor, "stop", "--time", fmt.Sprint(stopTime), containerName); err != nil {
+				logger.Warningf("Unable to stop container %s: %v", containerName, err)
+			}
+		case <-done:
+		}
+	}()
+	start = runner.clock.Now()
+	err = executor.Run(context.Background(), startCommand)
+	startDuration := runner.clock.Now().Sub(start)
+	close(done)
+	for _, rewriter := range rewriters {
+		rewriter.Flush()
+	}
+
+	exitCode := getExitCode(err)
+	if _, exited := err.(exitCoder); err != nil && !exited {
+		// Docker could not be run at all
+		fmt.Fprintln(streams.Err, err)
+	} else if err != nil && stderr.Len() > 0 {
+		fmt.Fprintf(streams.Err, "%s\n%s", stderr.String(), createCommand)
+		if runtime.GOOS == "windows" {
+			fmt.Fprint(streams.Err, windowsMessage)
+		}
+		exitCode = 1
+	}
+
+	if transfer != nil {
+		// The files are copied back even if the command failed, they may help understanding why
+		start := runner.clock.Now()
+		summary, err := transfer.download(context.Background(), cli, containerName)
+		result.Timings.Add(PhaseTransfer, runner.clock.Now().Sub(start))
+		if err != nil {
+			fmt.Fprintf(streams.Err, "Unable to copy the workspace from the container: %v\n", err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		} else if len(summary.Added)+len(summary.Updated)+len(summary.Deleted)+len(summary.Conflicts) > 0 {
+			summary.Print(streams.Err)
+		}
+	}
+
+	start = runner.clock.Now()
+	containerStart, containerRun := runner.getContainerTimings(executor, containerName, startDuration)
+	result.Timings.Add(PhaseStart, containerStart)
+	result.Timings.Add(PhaseRun, containerRun)
+	remove()
+	result.Timings.Add(PhaseCleanup, runner.clock.Now().Sub(start))
+
+	return finish(exitCode)
+}
+
+// lock takes the lock of the run, if the profile is locked, and returns the function releasing it.
+func (runner *Runner) lock(ctx context.Context, mode LockMode, workspace, profile string) (release func(), err error) {
+	folder := runner.lockFolder
+	if folder == "" {
+		if folder, err = DefaultLockFolder(); err != nil {
+			return nil, err
+		}
+	}
+	fileName, err := getLockFile(folder, mode, workspace, profile)
+	if err != nil || fileName == "" {
+		return func() {}, err
+	}
+
+	holder := LockHolder{PID: os.Getpid(), Profile: profile, Workspace: workspace, Started: runner.clock.Now()}
+	holder.Host, _ = os.Hostname()
+	if currentUser, err := runner.getUser(); err == nil {
+		holder.User = currentUser.Username
+	}
+	if runner.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runner.lockTimeout)
+		defer cancel()
+	}
+
+	runner.logger.Debugf("Taking lock %s", fileName)
+	return acquireLock(ctx, fileName, holder, func(current LockHolder) {
+		fmt.Fprintf(runner.streams.Err, "Waiting for the %s lock of %s held by %s\n", mode, workspace, current)
+	})
+}
+
+// getContainerTimings splits the time spent by docker start between the start of the container and the command,
+// using the time the container started and finished. The whole time is considered as running time if it is unknown.
+func (runner *Runner) getContainerTimings(executor Executor, containerName string, total time.Duration) (start, run time.Duration) {
+	state, err := executeDocker(context.Background(), executor, "inspect", "--format", "{{.State.StartedAt}} {{.State.FinishedAt}}", containerName)
+	times := strings.Fields(state)
+	if err != nil || len(times) != 2 {
+		return 0, total
+	}
+	startedAt, err1 := time.Parse(time.RFC3339Nano, times[0])
+	finishedAt, err2 := time.Parse(time.RFC3339Nano, times[1])
+	if err1 != nil || err2 != nil || finishedAt.Before(startedAt) {
+		return 0, total
+	}
+
+	run = finishedAt.Sub(startedAt)
+	if run > total {
+		// The daemon clock is not the one of the host (i.e. a virtual machine)
+		run = total
+	}
+	return total - run, run
+}
+
+func (runner *Runner) getWorkingDirectory() (string, error) {
+	if runner.workingDir == "" {
+		return getCwd()
+	}
+	cwd, err := filepath.EvalSymlinks(runner.workingDir)
+	if err != nil {
+		return "", err
+	}
+	cwd, err = filepath.Abs(cwd)
+	return filepath.ToSlash(cwd), err
+}
+
+func (runner *Runner) getEnvironment() []string {
+	if runner.environ == nil {
+		return os.Environ()
+	}
+	return runner.environ
+}
+
+func (runner *Runner) getUser() (*user.User, error) {
+	if runner.user != nil {
+		return runner.user, nil
+	}
+	return user.Current()
+}
+
+// getClient returns the client of the docker daemon the docker CLI run with the endpoint options and the environment
+// connects to, the clients are created once.
+func (runner *Runner) getClient(endpoint dockerEndpoint, environ []string) (client.APIClient, error) {
+	if runner.clientSet {
+		return runner.client, nil
+	}
+	endpoint, err := endpoint.resolve(environ)
+	if err != nil {
+		return nil, err
+	}
+
+	runner.clientsLock.Lock()
+	defer runner.clientsLock.Unlock()
+	if cli, found := runner.clients[endpoint]; found {
+		return cli, nil
+	}
+	cli, err := endpoint.newClient()
+	if err != nil {
+		return nil, err
+	}
+	if runner.clients == nil {
+		runner.clients = map[dockerEndpoint]client.APIClient{}
+	}
+	runner.clients[endpoint] = cli
+	return cli, nil
+}
+
+// recordRun appends the run to the audit log, failing to do so does not fail the run. The client may be nil.
+func (runner *Runner) recordRun(ctx context.Context, cli client.APIClient, config *DockerConfig, result Result, username, cwd string, args []string) {
+	entry := AuditEntry{
+		Time:             result.Started,
+		User:             username,
+		Profile:          config.Environment["RUNCONTAINER_PROFILE"],
+		ConfigFile:       config.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"],
+		Image:            config.GetImageName(),
+		Args:             args,
+		WorkingDirectory: cwd,
+		ExitCode:         result.ExitCode,
+		Duration:         result.Duration().Seconds(),
+	}
+	// The image is known once the container has been run, there is no client if the run failed before
+	if cli != nil {
+		if image, err := findImage(ctx, cli, entry.Image); err == nil && image != nil {
+			entry.ImageDigest = image.ID
+			if len(image.RepoDigests) > 0 {
+				entry.ImageDigest = image.RepoDigests[0]
+			}
+		}
+	}
+
+	if err := runner.audit.Append(entry); err != nil {
+		runner.logger.Warningf("Unable to write the audit log: %v", err)
+	}
+}
+
+// recordFailure appends a run that failed before its container could be run to the audit log.
+func (runner *Runner) recordFailure(ctx context.Context, config *DockerConfig, result Result, args []string) {
+	result.ExitCode = 1
+	result.Finished = runner.clock.Now()
+	var username string
+	if currentUser, err := runner.getUser(); err == nil {
+		username = currentUser.Username
+	}
+	cwd, _ := runner.getWorkingDirectory()
+	runner.recordRun(ctx, nil, config, result, username, cwd, args)
+}
+
+// getImageUsername returns the user owning the home folder in the image, or the current user if the image does not
+// define one or has not been pulled yet.
+func (runner *Runner) getImageUsername(ctx context.Context, cli client.APIClient, imageName, username string) (string, error) {
+	runner.logger.Debugf("Looking up image %s", imageName)
+	imageSummary, err := findImage(ctx, cli, imageName)
+	if err != nil {
+		return "", err
+	}
+	if imageSummary == nil {
+		runner.logger.Debugf("Image %s has not been pulled, using user %s", imageName, username)
+	}
+
+	if imageSummary != nil {
+		image, _, err := cli.ImageInspectWithRaw(ctx, imageSummary.ID)
+		if err != nil {
+			return "", err
+		}
+		if image.Config != nil && image.Config.User != "" {
+			// If an explicit user is defined in the image, we use that user instead of the actual one
+			// This ensure to not mount a folder with no permission to write into it
+			username = image.Config.User
+			runner.logger.Debugf("Image %s runs as user %s", imageName, username)
+		}
+	}
+
+	// Fix for Windows containing the domain name in the Username (e.g. ACME\jsmith)
+	// The backslash is not accepted for a Docker volume path
+	splitUsername := strings.Split(username, "\\")
+	return splitUsername[len(splitUsername)-1], nil
+}
+
+// getImageEntrypoint returns the entry point defined by the image, pulling the image first if it is not available.
+func (runner *Runner) getImageEntrypoint(ctx context.Context, cli client.APIClient, executor Executor, imageName string) ([]string, error) {
+	imageSummary, err := findImage(ctx, cli, imageName)
+	if err != nil {
+		return nil, err
+	}
+	if imageSummary == nil {
+		runner.logger.Debugf("Pulling image %s to get its entry point", imageName)
+		if _, err := executeDocker(ctx, executor, "pull", imageName); err != nil {
+			return nil, fmt.Errorf("unable to pull image %s: %v", imageName, err)
+		}
+		if imageSummary, err = findImage(ctx, cli, imageName); err != nil {
+			return nil, err
+		}
+		if imageSummary == nil {
+			return nil, fmt.Errorf("unable to find image %s to get its entry point", imageName)
+		}
+	}
+
+	image, _, err := cli.ImageInspectWithRaw(ctx, imageSummary.ID)
+	if err != nil {
+		return nil, err
+	}
+	if image.Config == nil {
+		return nil, nil
+	}
+	return image.Config.Entrypoint, nil
+}
+
+// getOptionValue returns the value of an option in docker arguments (--option value or --option=value). As with
+// docker, the last value wins if the option is given several times.
+func getOptionValue(args []string, option string) string {
+	var value string
+	for i, arg := range args {
+		if arg == option && i+1 < len(args) {
+			value = args[i+1]
+		}
+		if strings.HasPrefix(arg, option+"=") {
+			value = strings.TrimPrefix(arg, option+"=")
+		}
+	}
+	return value
+}
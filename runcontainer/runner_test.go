@@ -0,0 +1,345 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// fakeClient is a docker client knowing a single image.
+type fakeClient struct {
+	client.APIClient
+	image *types.ImageInspect
+}
+
+func (cli *fakeClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	if cli.image == nil {
+		return nil, nil
+	}
+	return []types.ImageSummary{{ID: cli.image.ID}}, nil
+}
+
+func (cli *fakeClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	return *cli.image, nil, nil
+}
+
+type fixedClock struct{ now time.Time }
+
+func (clock fixedClock) Now() time.Time { return clock.now }
+
+// testRun holds a runner using fakes for everything outside of the process and the folders it uses.
+type testRun struct {
+	runner   *Runner
+	executor *FakeExecutor
+	folder   string
+	out      bytes.Buffer
+	err      bytes.Buffer
+}
+
+func newTestRun(t *testing.T, options ...Option) *testRun {
+	folder, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder = filepath.ToSlash(folder)
+
+	for _, name := range []string{"workspace/project", "home/jdoe"} {
+		if err := os.MkdirAll(filepath.Join(folder, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socket := filepath.Join(folder, "docker.sock")
+	if err := ioutil.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := &testRun{executor: &FakeExecutor{}, folder: folder}
+	run.runner = NewRunner(append(run.options(), options...)...)
+	run.runner.dockerSocket = socket
+	run.runner.workspaceRoot = filepath.Join(folder, "workspace")
+	run.runner.lockFolder = filepath.Join(folder, "locks")
+	run.runner.volumeUsesFile = filepath.Join(folder, "volumes.json")
+	return run
+}
+
+// options returns the options of the test runner, for the functions creating their own runner.
+func (run *testRun) options() []Option {
+	return []Option{
+		WithExecutor(run.executor),
+		WithClient(&fakeClient{}),
+		WithUser(&user.User{Uid: "1000", Gid: "1000", Username: "jdoe", HomeDir: filepath.Join(run.folder, "home/jdoe")}),
+		WithWorkingDirectory(filepath.Join(run.folder, "workspace/project")),
+		WithEnvironment([]string{"PATH=/usr/bin:/bin", "TF_LOG=debug"}),
+		WithStreams(Streams{In: strings.NewReader(""), Out: &run.out, Err: &run.err}),
+		WithClock(fixedClock{time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)}),
+	}
+}
+
+func (run *testRun) profile() *DockerConfig {
+	return &DockerConfig{
+		Image:                "alpine",
+		ImageTag:             "3.14",
+		MountPoint:           "current_sources",
+		TempDirMountLocation: MountLocHost,
+		Environment:          map[string]string{"RUNCONTAINER_PROFILE": "default"},
+	}
+}
+
+var reRunID = regexp.MustCompile(`runcontainer-[0-9a-f]{12}`)
+var reGroup = regexp.MustCompile(`--group-add \S+`)
+
+// commandLines returns the recorded commands with the paths depending on the test machine replaced by placeholders.
+func (run *testRun) commandLines(t *testing.T) string {
+	temp, err := filepath.EvalSymlinks(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join(run.executor.CommandLines(), "\n") + "\n"
+	lines = strings.Replace(lines, run.folder, "<TEST>", -1)
+	// Only the cache folder mounted from the host temporary folder is replaced, /tmp in the container is not
+	lines = strings.Replace(lines, filepath.ToSlash(filepath.Join(temp, "runcontainer-cache")), "<TEMP>/runcontainer-cache", -1)
+	lines = reRunID.ReplaceAllString(lines, "<RUN_ID>")
+	return reGroup.ReplaceAllString(lines, "--group-add <GID>")
+}
+
+func assertGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	fileName := filepath.Join("testdata", "runner", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("%v (run the tests with -update to create the golden file)", err)
+	}
+	if actual != string(expected) {
+		t.Errorf("command lines differ from %s:\n got: %s\nwant: %s", fileName, actual, expected)
+	}
+}
+
+func TestRunnerArguments(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the golden files contain the linux docker arguments")
+	}
+
+	for _, mountHome := range []bool{false, true} {
+		for _, tempLocation := range []MountLocation{MountLocNone, MountLocHost, MountLocVolume} {
+			for _, dockerMount := range []bool{false, true} {
+				for _, currentUser := range []bool{false, true} {
+					name := fmt.Sprintf("home-%t_temp-%s_docker-%t_user-%t", mountHome, tempLocation, dockerMount, currentUser)
+					t.Run(name, func(t *testing.T) {
+						run := newTestRun(t)
+						profile := run.profile()
+						profile.MountHomeDirectory = mountHome
+						profile.TempDirMountLocation = tempLocation
+						profile.WithDockerMount = dockerMount
+						profile.WithCurrentUser = currentUser
+
+						result, err := run.runner.Run(context.Background(), profile, []string{"terraform", "plan"})
+						if err != nil {
+							t.Fatal(err)
+						}
+						if result.ExitCode != 0 {
+							t.Errorf("exit code = %d, want 0 (stderr: %s)", result.ExitCode, run.err.String())
+						}
+						assertGolden(t, name, run.commandLines(t))
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestRunnerImageUser(t *testing.T) {
+	run := newTestRun(t, WithClient(&fakeClient{image: &types.ImageInspect{ID: "sha256:1234", Config: &container.Config{User: "terraform"}}}))
+
+	result, err := run.runner.Run(context.Background(), run.profile(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !listContainsElement(result.DockerArgs, "tgf-terraform:/home/terraform") {
+		t.Errorf("the home volume of the image user is not mounted: %v", result.DockerArgs)
+	}
+}
+
+func TestRunnerHooks(t *testing.T) {
+	tests := []struct {
+		name           string
+		dockerExitCode int
+		hookFailure    string
+		wantExitCode   int
+		wantScripts    []string
+	}{
+		{"success", 0, "", 0, []string{"before", "after-success", "always"}},
+		{"container failure", 3, "", 3, []string{"before", "after-failure", "always"}},
+		{"before failure", 0, "before", 1, []string{"before", "after-failure", "always"}},
+		{"after-success failure", 0, "after-success", 1, []string{"before", "after-success", "always"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newTestRun(t, WithClient(&fakeClient{image: &types.ImageInspect{ID: "sha256:1234", Config: &container.Config{Entrypoint: []string{"terraform"}}}}))
+			run.executor.Handler = func(ctx context.Context, command Command) error {
+				if command.Script != "" && command.Script == tt.hookFailure {
+					return &ExitError{Code: 2}
+				}
+				if command.Name == "docker" && command.Args[0] == "start" && tt.dockerExitCode != 0 {
+					return &ExitError{Code: tt.dockerExitCode}
+				}
+				return nil
+			}
+
+			profile := run.profile()
+			profile.TempDirMountLocation = MountLocNone
+			profile.Hooks = &Hooks{
+				Before:        []string{"before"},
+				AfterSuccess:  []string{"after-success"},
+				AfterFailure:  []string{"after-failure"},
+				Always:        []string{"always"},
+				ContainerInit: []string{"init"},
+			}
+
+			result, err := run.runner.Run(context.Background(), profile, []string{"make"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+
+			var scripts []string
+			for _, command := range run.executor.Commands() {
+				if command.Script != "" {
+					scripts = append(scripts, command.Script)
+					if command.Dir != filepath.Join(run.folder, "workspace/project") {
+						t.Errorf("hook %s is run in %s", command.Script, command.Dir)
+					}
+				}
+			}
+			if !reflect.DeepEqual(scripts, tt.wantScripts) {
+				t.Errorf("hooks = %v, want %v", scripts, tt.wantScripts)
+			}
+			if want := "set -e\ninit\nexec 'terraform' \"$@\""; !listContainsElement(result.DockerArgs, want) {
+				t.Errorf("the container init script does not run the image entry point: %v", result.DockerArgs)
+			}
+		})
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	run := newTestRun(t, WithTimeout(50*time.Millisecond))
+	stopped := make(chan bool)
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		switch {
+		case command.Script == "cleanup":
+			if ctx.Err() != nil {
+				t.Error("the failure hooks should be given the grace period")
+			}
+		case command.Args[0] == "stop":
+			close(stopped)
+		case command.Args[0] == "start":
+			// The container is killed by docker stop
+			<-stopped
+			return &ExitError{Code: 137}
+		}
+		return nil
+	}
+
+	profile := run.profile()
+	profile.TempDirMountLocation = MountLocNone
+	profile.StopSignal = "SIGINT"
+	profile.StopGracePeriod = "1500ms"
+	profile.Hooks = &Hooks{AfterFailure: []string{"cleanup"}}
+
+	result, err := run.runner.Run(context.Background(), profile, []string{"terraform", "apply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != TimeoutExitCode || !result.TimedOut {
+		t.Errorf("exit code = %d (timed out %v), want %d", result.ExitCode, result.TimedOut, TimeoutExitCode)
+	}
+	for _, want := range []string{"--stop-signal SIGINT", "docker stop --time 2 <RUN_ID>\n", "cleanup\n", "docker rm -f -v <RUN_ID>\n"} {
+		if lines := run.commandLines(t); !strings.Contains(lines, want) {
+			t.Errorf("commands do not contain %q:\n%s", want, lines)
+		}
+	}
+	if !strings.Contains(run.err.String(), "Timed out after 50ms") {
+		t.Errorf("unexpected error output %q", run.err.String())
+	}
+}
+
+func TestRunnerDoesNotModifyProfile(t *testing.T) {
+	run := newTestRun(t)
+	profile := run.profile()
+	profile.Services = map[string]*ServiceConfig{"db": {Image: "postgres"}}
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		if command.Name == "docker" && command.Args[0] == "inspect" {
+			fmt.Fprint(command.Stdout, "running")
+		}
+		return nil
+	}
+
+	if _, err := run.runner.Run(context.Background(), profile, []string{"psql"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"RUNCONTAINER_PROFILE": "default"}; !reflect.DeepEqual(profile.Environment, want) {
+		t.Errorf("profile environment = %v, want %v", profile.Environment, want)
+	}
+	if !listContainsElement(run.executor.CommandLines(), "docker network rm "+reRunID.FindString(strings.Join(run.executor.CommandLines(), " "))) {
+		t.Errorf("the services network is not removed: %v", run.executor.CommandLines())
+	}
+}
+
+func TestRunnerMirrorHostPath(t *testing.T) {
+	run := newTestRun(t, WithClient(&fakeClient{image: &types.ImageInspect{ID: "sha256:1234", Config: &container.Config{}}}))
+	run.runner.workspaceRoot = ""
+	profile := run.profile()
+	profile.MirrorHostPath = true
+
+	result, err := run.runner.Run(context.Background(), profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd := filepath.Join(run.folder, "workspace/project")
+	if !listContainsElement(result.DockerArgs, cwd+":"+cwd) {
+		t.Errorf("the current directory is not mirrored: %v", result.DockerArgs)
+	}
+	if !listContainsElement(result.DockerArgs, "tgf-jdoe:/home/jdoe") {
+		t.Errorf("the home volume is not mounted: %v", result.DockerArgs)
+	}
+}
+
+func TestRunnerArgsEnvironment(t *testing.T) {
+	run := newTestRun(t)
+
+	if _, err := run.runner.Run(context.Background(), run.profile(), []string{"terraform", "plan"}); err != nil {
+		t.Fatal(err)
+	}
+	create := run.executor.Commands()[0]
+	if !listContainsElement(create.Env, "RUNCONTAINER_ARGS=terraform plan") {
+		t.Errorf("RUNCONTAINER_ARGS is not the arguments of the run: %v", create.Env)
+	}
+}
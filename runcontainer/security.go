@@ -0,0 +1,265 @@
+package runcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SecurityPresetStrict locks the container down: read-only root filesystem, all capabilities dropped and no privilege
+// escalation. The docker mount is refused unless allow-docker-mount is set, the unconfined profiles unless
+// allow-unconfined is set, as are the home directory mount and the docker-options weakening the isolation of the
+// container.
+const SecurityPresetStrict = "strict"
+
+// strictAllowedOptions are the docker options accepted in docker-options with the strict preset, with true if they
+// take a value. The other options are refused, the security settings (which require trust) must be used instead.
+var strictAllowedOptions = map[string]bool{
+	"--add-host": true, "--cap-drop": true, "--cgroupns": true, "--cpu-shares": true, "-c": true, "--cpus": true,
+	"--cpuset-cpus": true, "--cpuset-mems": true, "--dns": true, "--dns-option": true, "--dns-search": true,
+	"--domainname": true, "--entrypoint": true, "--env": true, "-e": true, "--env-file": true, "--expose": true,
+	"--health-cmd": true, "--health-interval": true, "--health-retries": true, "--health-start-period": true,
+	"--health-timeout": true, "--hostname": true, "-h": true, "--init": false, "--interactive": false, "-i": false,
+	"--ip": true, "--ip6": true, "--ipc": true, "--label": true, "-l": true, "--label-file": true, "--log-driver": true,
+	"--log-opt": true, "--mac-address": true, "--memory": true, "-m": true, "--memory-reservation": true,
+	"--memory-swap": true, "--mount": true, "--net": true, "--network": true, "--network-alias": true,
+	"--no-healthcheck": false, "--pid": true, "--pids-limit": true, "--platform": true, "--publish": true, "-p": true,
+	"--publish-all": false, "-P": false, "--pull": true, "--read-only": false, "--restart": true, "--rm": false,
+	"--shm-size": true, "--stop-signal": true, "--stop-timeout": true, "--tmpfs": true, "--tty": false, "-t": false,
+	"--ulimit": true, "--user": true, "-u": true, "--uts": true, "--volume": true, "-v": true, "--workdir": true,
+	"-w": true,
+}
+
+// strictNamespaceOptions are the docker options refused with the strict preset when they share a namespace of the host
+// or of another container.
+var strictNamespaceOptions = []string{"--cgroupns", "--ipc", "--net", "--network", "--pid", "--uts"}
+
+// strictRefusedHostFolders are the host folders that cannot be mounted with the strict preset, nor their sub folders.
+// The root folder is refused as well.
+var strictRefusedHostFolders = []string{"/boot", "/dev", "/etc", "/proc", "/root", "/run", "/sys", "/var/run"}
+
+// SecurityConfig restricts what the container can do. In the configuration file, it is either the name of a preset
+// (i.e. "strict") or an object, the settings of an object override the settings of its preset.
+type SecurityConfig struct {
+	Preset string `yaml:"preset,omitempty" json:"preset,omitempty" hcl:"preset,omitempty"`
+	// ReadOnlyRootfs mounts the root filesystem of the container read-only, /tmp and the home are mounted in memory
+	// unless they are persisted in a volume
+	ReadOnlyRootfs bool     `yaml:"read-only-rootfs,omitempty" json:"read-only-rootfs,omitempty" hcl:"read-only-rootfs,omitempty"`
+	CapDrop        []string `yaml:"cap-drop,omitempty" json:"cap-drop,omitempty" hcl:"cap-drop,omitempty"`
+	CapAdd         []string `yaml:"cap-add,omitempty" json:"cap-add,omitempty" hcl:"cap-add,omitempty"`
+	// NoNewPrivileges prevents the processes from gaining privileges (i.e. through sudo or setuid binaries)
+	NoNewPrivileges bool `yaml:"no-new-privileges,omitempty" json:"no-new-privileges,omitempty" hcl:"no-new-privileges,omitempty"`
+	// Seccomp is the path of a seccomp profile (relative to the configuration file), or unconfined
+	Seccomp string `yaml:"seccomp,omitempty" json:"seccomp,omitempty" hcl:"seccomp,omitempty"`
+	// AppArmor is the name of an AppArmor profile loaded on the daemon host, or unconfined
+	AppArmor string `yaml:"apparmor,omitempty" json:"apparmor,omitempty" hcl:"apparmor,omitempty"`
+	// Userns is the user namespace mode of the container (i.e. host when the daemon remaps the users)
+	Userns string `yaml:"userns,omitempty" json:"userns,omitempty" hcl:"userns,omitempty"`
+	// AllowDockerMount accepts the docker mount with the strict preset, although it gives root access to the host
+	AllowDockerMount bool `yaml:"allow-docker-mount,omitempty" json:"allow-docker-mount,omitempty" hcl:"allow-docker-mount,omitempty"`
+	// AllowUnconfined accepts the unconfined seccomp and AppArmor profiles and the host user namespace with the strict
+	// preset
+	AllowUnconfined bool `yaml:"allow-unconfined,omitempty" json:"allow-unconfined,omitempty" hcl:"allow-unconfined,omitempty"`
+}
+
+// UnmarshalJSON accepts the name of a preset or a security object.
+func (security *SecurityConfig) UnmarshalJSON(data []byte) error {
+	var preset string
+	if err := json.Unmarshal(data, &preset); err == nil {
+		*security = SecurityConfig{Preset: preset}
+		return nil
+	}
+	type plainSecurityConfig SecurityConfig
+	return json.Unmarshal(data, (*plainSecurityConfig)(security))
+}
+
+// getSecurity returns the security settings of the profile with the values of its preset applied, nil if there are
+// none. It fails if the profile mounts the docker socket or unconfines the container in strict mode without allowing it
+// explicitly.
+func (config *DockerConfig) getSecurity() (*SecurityConfig, error) {
+	if config.Security == nil {
+		return nil, nil
+	}
+	security := *config.Security
+	switch security.Preset {
+	case "":
+	case SecurityPresetStrict:
+		security.ReadOnlyRootfs = true
+		security.NoNewPrivileges = true
+		if len(security.CapDrop) == 0 {
+			security.CapDrop = []string{"ALL"}
+		}
+		if config.WithDockerMount && !security.AllowDockerMount {
+			return nil, fmt.Errorf("with-docker-mount gives root access to the docker host, set allow-docker-mount in the security settings to use it with the %s preset", SecurityPresetStrict)
+		}
+		if setting := security.getUnconfinedSetting(); setting != "" && !security.AllowUnconfined {
+			return nil, fmt.Errorf("%s weakens the isolation of the container, set allow-unconfined in the security settings to use it with the %s preset", setting, SecurityPresetStrict)
+		}
+		if config.MountHomeDirectory {
+			return nil, fmt.Errorf("mount-home-directory gives access to the home of the user on the host, it cannot be used with the %s preset", SecurityPresetStrict)
+		}
+		if err := checkStrictDockerOptions(config.DockerOptions); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown security preset '%s', expected %s", security.Preset, SecurityPresetStrict)
+	}
+
+	if copied, _ := config.isWorkspaceCopied(); copied && security.ReadOnlyRootfs {
+		return nil, fmt.Errorf("read-only-rootfs cannot be used with workspace-transfer %s, the workspace cannot be copied into a read-only container", config.WorkspaceTransfer)
+	}
+
+	if security.Seccomp != "" && security.Seccomp != "unconfined" && !filepath.IsAbs(security.Seccomp) {
+		if configFile := config.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"]; configFile != "" {
+			security.Seccomp = filepath.Join(filepath.Dir(configFile), security.Seccomp)
+		}
+	}
+	return &security, nil
+}
+
+// checkStrictDockerOptions fails if the docker options weaken the isolation of the container: options that are not
+// allowed, shared namespaces or mounts of the docker socket or of the system folders of the host.
+func checkStrictDockerOptions(options []string) error {
+	var args []string
+	for _, option := range options {
+		args = append(args, strings.Fields(option)...)
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected docker option %s", arg)
+		}
+		if arg == "--privileged=false" {
+			continue
+		}
+
+		name, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			if index := strings.Index(arg, "="); index > 0 {
+				name, value, hasValue = arg[:index], arg[index+1:], true
+			}
+		} else if len(arg) > 2 {
+			// Short option with its value (-v/data:/data) or short flags (-it)
+			name, value, hasValue = arg[:2], strings.TrimPrefix(arg[2:], "="), true
+		}
+
+		takesValue, allowed := strictAllowedOptions[name]
+		if !allowed {
+			return fmt.Errorf("docker option %s weakens the isolation of the container or is not known, it cannot be used with the %s preset", name, SecurityPresetStrict)
+		}
+		switch {
+		case !takesValue && hasValue && !strings.HasPrefix(arg, "--"):
+			for _, flag := range arg[2:] {
+				if takesValue, allowed := strictAllowedOptions["-"+string(flag)]; !allowed || takesValue {
+					return fmt.Errorf("docker option -%c cannot be combined in %s with the %s preset", flag, arg, SecurityPresetStrict)
+				}
+			}
+			continue
+		case !takesValue:
+			continue
+		case !hasValue:
+			if i+1 == len(args) {
+				return fmt.Errorf("docker option %s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if err := checkStrictDockerOption(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStrictDockerOption fails if the value of an allowed docker option weakens the isolation of the container.
+func checkStrictDockerOption(name, value string) error {
+	if listContainsElement(strictNamespaceOptions, name) && (value == "host" || strings.HasPrefix(value, "container:")) {
+		return fmt.Errorf("docker option %s %s shares a namespace of the host or of another container, it cannot be used with the %s preset", name, value, SecurityPresetStrict)
+	}
+	if name != "-v" && name != "--volume" && name != "--mount" {
+		return nil
+	}
+
+	if strings.Contains(value, "docker.sock") || strings.Contains(value, "docker_engine") {
+		return fmt.Errorf("docker option %s %s gives root access to the docker host, set with-docker-mount and allow-docker-mount to mount the docker socket with the %s preset", name, value, SecurityPresetStrict)
+	}
+	source := strings.SplitN(value, ":", 2)[0]
+	if name == "--mount" {
+		source = ""
+		for _, field := range strings.Split(value, ",") {
+			if split := strings.SplitN(field, "=", 2); len(split) == 2 && (split[0] == "source" || split[0] == "src") {
+				source = split[1]
+			}
+		}
+	}
+	if !strings.HasPrefix(source, "/") {
+		// Named volumes do not give access to the host
+		return nil
+	}
+	source = path.Clean(source)
+	for _, folder := range strictRefusedHostFolders {
+		if source == "/" || containsPath(folder, source) {
+			return fmt.Errorf("docker option %s %s mounts the host folder %s, it cannot be used with the %s preset", name, value, source, SecurityPresetStrict)
+		}
+	}
+	return nil
+}
+
+// getDockerArgs returns the docker create options applying the security settings. The folders are mounted in memory
+// when the root filesystem is read-only.
+func (security *SecurityConfig) getDockerArgs(tmpfs ...string) []string {
+	var args []string
+	if security.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+		for _, folder := range tmpfs {
+			args = append(args, "--tmpfs", folder)
+		}
+	}
+	for _, capability := range security.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, capability := range security.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	if security.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if security.Seccomp != "" {
+		args = append(args, "--security-opt", "seccomp="+security.Seccomp)
+	}
+	if security.AppArmor != "" {
+		args = append(args, "--security-opt", "apparmor="+security.AppArmor)
+	}
+	if security.Userns != "" {
+		args = append(args, "--userns", security.Userns)
+	}
+	return args
+}
+
+// getUnconfinedSetting returns the setting disabling a confinement of the container, empty if there is none.
+func (security *SecurityConfig) getUnconfinedSetting() string {
+	switch {
+	case security.Seccomp == "unconfined":
+		return "seccomp unconfined"
+	case security.AppArmor == "unconfined":
+		return "apparmor unconfined"
+	case security.Userns == "host":
+		return "userns host"
+	}
+	return ""
+}
+
+// getTrustedSettings returns the settings weakening the isolation of the container, nil if there are none.
+func (security *SecurityConfig) getTrustedSettings() *SecurityConfig {
+	if security == nil {
+		return nil
+	}
+	trusted := SecurityConfig{CapAdd: security.CapAdd, Seccomp: security.Seccomp, AppArmor: security.AppArmor, Userns: security.Userns, AllowDockerMount: security.AllowDockerMount, AllowUnconfined: security.AllowUnconfined}
+	if trusted.Seccomp == "" && trusted.AppArmor == "" && trusted.Userns == "" && len(trusted.CapAdd) == 0 && !trusted.AllowDockerMount && !trusted.AllowUnconfined {
+		return nil
+	}
+	return &trusted
+}
@@ -0,0 +1,124 @@
+package runcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSecurityConfigValidation(t *testing.T) {
+	var config DockerConfig
+	if err := json.Unmarshal([]byte(`{"with-docker-mount": true, "security": "strict"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Security == nil || config.Security.Preset != SecurityPresetStrict {
+		t.Fatalf("security = %+v, want the strict preset", config.Security)
+	}
+	if _, err := config.getSecurity(); err == nil {
+		t.Error("the docker mount should be refused with the strict preset")
+	}
+
+	config.Security = &SecurityConfig{Preset: SecurityPresetStrict, AllowDockerMount: true}
+	if _, err := config.getSecurity(); err != nil {
+		t.Errorf("the docker mount should be allowed: %v", err)
+	}
+	if config.Security.getTrustedSettings() == nil {
+		t.Error("allow-docker-mount should require trust")
+	}
+	if (&SecurityConfig{AllowUnconfined: true}).getTrustedSettings() == nil {
+		t.Error("allow-unconfined should require trust")
+	}
+	if (&SecurityConfig{Preset: SecurityPresetStrict, CapDrop: []string{"NET_RAW"}}).getTrustedSettings() != nil {
+		t.Error("settings hardening the container should not require trust")
+	}
+
+	for _, security := range []SecurityConfig{{Seccomp: "unconfined"}, {AppArmor: "unconfined"}, {Userns: "host"}} {
+		security.Preset = SecurityPresetStrict
+		config = DockerConfig{Security: &security}
+		if _, err := config.getSecurity(); err == nil {
+			t.Errorf("%+v should be refused with the strict preset", security)
+		}
+		security.AllowUnconfined = true
+		if _, err := config.getSecurity(); err != nil {
+			t.Errorf("%+v should be allowed: %v", security, err)
+		}
+	}
+
+	config = DockerConfig{Security: &SecurityConfig{Preset: "paranoid"}}
+	if _, err := config.getSecurity(); err == nil {
+		t.Error("unknown presets should be rejected")
+	}
+}
+
+func TestSecurityStrictDockerOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DockerConfig
+		wantErr bool
+	}{
+		{"hardening options", DockerConfig{DockerOptions: []string{"--memory 1g", "--network build", "-v /data:/data", "--privileged=false", "--pids-limit=100"}}, false},
+		{"spaces", DockerConfig{DockerOptions: []string{"--memory  1g", "\t--init", "-it", "-e=TF_LOG", "-v/data:/data"}}, false},
+		{"private namespaces", DockerConfig{DockerOptions: []string{"--pid private", "--ipc=private", "--cgroupns private", "--network none"}}, false},
+		{"volumes", DockerConfig{DockerOptions: []string{"-v cache:/cache", "-v /etcetera:/etcetera", "--mount type=volume,source=etc,target=/etc"}}, false},
+		{"privileged", DockerConfig{DockerOptions: []string{"--privileged"}}, true},
+		{"cap-add", DockerConfig{DockerOptions: []string{"--cap-add SYS_ADMIN"}}, true},
+		{"cap-add value", DockerConfig{DockerOptions: []string{"--cap-add=ALL"}}, true},
+		{"seccomp", DockerConfig{DockerOptions: []string{"--security-opt seccomp=unconfined"}}, true},
+		{"apparmor", DockerConfig{DockerOptions: []string{"--security-opt=apparmor=unconfined"}}, true},
+		{"userns", DockerConfig{DockerOptions: []string{"--userns=host"}}, true},
+		{"pid", DockerConfig{DockerOptions: []string{"--pid host"}}, true},
+		{"ipc", DockerConfig{DockerOptions: []string{"--ipc=host"}}, true},
+		{"network", DockerConfig{DockerOptions: []string{"--network", "host"}}, true},
+		{"net", DockerConfig{DockerOptions: []string{"--net=host"}}, true},
+		{"uts", DockerConfig{DockerOptions: []string{"--uts host"}}, true},
+		{"cgroupns", DockerConfig{DockerOptions: []string{"--cgroupns host"}}, true},
+		{"pid container", DockerConfig{DockerOptions: []string{"--pid container:db"}}, true},
+		{"network container", DockerConfig{DockerOptions: []string{"--network=container:db"}}, true},
+		{"spaces host", DockerConfig{DockerOptions: []string{"--pid  host"}}, true},
+		{"device", DockerConfig{DockerOptions: []string{"--device /dev/sda"}}, true},
+		{"volumes from", DockerConfig{DockerOptions: []string{"--volumes-from db"}}, true},
+		{"unknown option", DockerConfig{DockerOptions: []string{"--sysctl kernel.shmmax=1"}}, true},
+		{"combined flags", DockerConfig{DockerOptions: []string{"-iv /:/host"}}, true},
+		{"missing value", DockerConfig{DockerOptions: []string{"--memory"}}, true},
+		{"root volume", DockerConfig{DockerOptions: []string{"-v /:/host"}}, true},
+		{"etc volume", DockerConfig{DockerOptions: []string{"--volume=/etc:/host/etc:ro"}}, true},
+		{"etc file volume", DockerConfig{DockerOptions: []string{"-v /etc/shadow:/shadow"}}, true},
+		{"unclean volume", DockerConfig{DockerOptions: []string{"-v /data/../etc:/host/etc"}}, true},
+		{"root mount", DockerConfig{DockerOptions: []string{"--mount type=bind,source=/,target=/host"}}, true},
+		{"etc mount", DockerConfig{DockerOptions: []string{"--mount type=bind,src=/etc,dst=/host/etc,readonly"}}, true},
+		{"socket volume", DockerConfig{DockerOptions: []string{"-v /var/run/docker.sock:/var/run/docker.sock"}}, true},
+		{"socket volume value", DockerConfig{DockerOptions: []string{"--volume=/run/docker.sock:/tmp/d.sock"}}, true},
+		{"socket short volume", DockerConfig{DockerOptions: []string{"-v/var/run/docker.sock:/var/run/docker.sock"}}, true},
+		{"socket mount", DockerConfig{DockerOptions: []string{"--mount type=bind,source=/var/run/docker.sock,target=/docker.sock"}}, true},
+		{"socket pipe", DockerConfig{DockerOptions: []string{`-v \\.\pipe\docker_engine:\\.\pipe\docker_engine`}}, true},
+		{"home directory", DockerConfig{MountHomeDirectory: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Security = &SecurityConfig{Preset: SecurityPresetStrict}
+			if _, err := config.getSecurity(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// The options are only refused with the strict preset
+			config.Security = &SecurityConfig{NoNewPrivileges: true}
+			if _, err := config.getSecurity(); err != nil {
+				t.Errorf("the options should be accepted without the strict preset: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunnerSecurity(t *testing.T) {
+	run := newTestRun(t)
+	profile := run.profile()
+	profile.TempDirMountLocation = MountLocNone
+	profile.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"] = "/project/runcontainer.json"
+	profile.Security = &SecurityConfig{Preset: SecurityPresetStrict, CapAdd: []string{"NET_BIND_SERVICE"}, Seccomp: "seccomp.json", Userns: "host", AllowUnconfined: true}
+	if _, err := run.runner.Run(context.Background(), profile, []string{"terraform", "plan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "security", run.commandLines(t))
+}
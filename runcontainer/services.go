@@ -0,0 +1,178 @@
+package runcontainer
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReadyInterval = time.Second
+	defaultReadyTimeout  = time.Minute
+)
+
+// ServiceConfig describes a container started next to the main container (i.e. a database). Services are attached
+// to a network created for the run and are reachable from the main container using their name as host name.
+type ServiceConfig struct {
+	Image       string            `yaml:"docker-image,omitempty" json:"docker-image,omitempty" hcl:"docker-image,omitempty"`
+	Command     string            `yaml:"command,omitempty" json:"command,omitempty" hcl:"command,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty" json:"environment,omitempty" hcl:"environment,omitempty"`
+	Ports       []string          `yaml:"ports,omitempty" json:"ports,omitempty" hcl:"ports,omitempty"`
+	ReadyCheck  *ReadyCheck       `yaml:"ready-check,omitempty" json:"ready-check,omitempty" hcl:"ready-check,omitempty"`
+}
+
+// ReadyCheck is a command run in the service container (with /bin/sh) until it succeeds. If there is no ready check,
+// the image HEALTHCHECK is used if defined.
+type ReadyCheck struct {
+	Command  string `yaml:"command,omitempty" json:"command,omitempty" hcl:"command,omitempty"`
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty" hcl:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty" json:"timeout,omitempty" hcl:"timeout,omitempty"`
+}
+
+// serviceRun holds the network and containers started for the services of a run.
+type serviceRun struct {
+	network        string
+	networkCreated bool
+	executor       Executor
+	logger         logrus.FieldLogger
+	containers     []string
+}
+
+// newRunID returns a unique name for the container and the network of a run.
+func newRunID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return fmt.Sprintf("runcontainer-%x", id)
+}
+
+// start creates the network, starts the services and waits until they are ready.
+func (run *serviceRun) start(ctx context.Context, services map[string]*ServiceConfig) error {
+	if _, err := executeDocker(ctx, run.executor, "network", "create", "--label", "runcontainer=true", run.network); err != nil {
+		return err
+	}
+	run.networkCreated = true
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := services[name]
+		if service == nil || service.Image == "" {
+			return fmt.Errorf("service %s has no docker-image", name)
+		}
+
+		containerName := fmt.Sprintf("%s-%s", run.network, name)
+		run.logger.Debugf("Starting service %s (%s) on network %s", name, service.Image, run.network)
+		dockerArgs := []string{"run", "-d", "--name", containerName, "--network", run.network, "--network-alias", name}
+		for key, value := range service.Environment {
+			dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", key, value))
+		}
+		for _, port := range service.Ports {
+			dockerArgs = append(dockerArgs, "-p", port)
+		}
+		dockerArgs = append(dockerArgs, service.Image)
+		if service.Command != "" {
+			dockerArgs = append(dockerArgs, strings.Split(service.Command, " ")...)
+		}
+
+		if _, err := executeDocker(ctx, run.executor, dockerArgs...); err != nil {
+			return fmt.Errorf("unable to start service %s: %v", name, err)
+		}
+		run.containers = append(run.containers, containerName)
+	}
+
+	for i, name := range names {
+		if err := run.waitForService(ctx, name, run.containers[i], services[name].ReadyCheck); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop removes the services containers and the network.
+func (run *serviceRun) stop(stderr io.Writer) {
+	if len(run.containers) > 0 {
+		if _, err := executeDocker(context.Background(), run.executor, append([]string{"rm", "-f", "-v"}, run.containers...)...); err != nil {
+			fmt.Fprintf(stderr, "Error removing services: %v\n", err)
+		}
+	}
+	if !run.networkCreated {
+		return
+	}
+
+	// The main container may still be detaching from the network, so we retry for a little while
+	var err error
+	for retry := 0; retry < 10; retry++ {
+		if _, err = executeDocker(context.Background(), run.executor, "network", "rm", run.network); err == nil {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	fmt.Fprintf(stderr, "Error removing network %s: %v\n", run.network, err)
+}
+
+func (run *serviceRun) waitForService(ctx context.Context, name, containerName string, check *ReadyCheck) error {
+	if check == nil {
+		check = &ReadyCheck{}
+	}
+	interval, err := parseDuration(check.Interval, defaultReadyInterval)
+	if err != nil {
+		return fmt.Errorf("invalid ready-check interval for service %s: %v", name, err)
+	}
+	timeout, err := parseDuration(check.Timeout, defaultReadyTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid ready-check timeout for service %s: %v", name, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := executeDocker(ctx, run.executor, "inspect", "--format", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", containerName)
+		if err != nil {
+			return err
+		}
+		status := strings.Fields(state)
+		if len(status) == 0 || status[0] != "running" {
+			logs, _ := executeDocker(ctx, run.executor, "logs", "--tail", "20", containerName)
+			return fmt.Errorf("service %s is not running:\n%s", name, logs)
+		}
+
+		ready := false
+		switch {
+		case check.Command != "":
+			_, err := executeDocker(ctx, run.executor, "exec", containerName, "/bin/sh", "-c", check.Command)
+			ready = err == nil
+		case len(status) > 1:
+			// The image defines a HEALTHCHECK
+			ready = status[1] == "healthy"
+		default:
+			ready = true
+		}
+		if ready {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s is not ready after %v", name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted while waiting for service %s", name)
+		case <-time.After(interval):
+		}
+	}
+}
+
+func parseDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(value)
+}
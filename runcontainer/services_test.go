@@ -0,0 +1,80 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"testing"
+)
+
+func TestServices(t *testing.T) {
+	services := map[string]*ServiceConfig{
+		"db":    {Image: "postgres", ReadyCheck: &ReadyCheck{Command: "pg_isready", Interval: "1ms"}},
+		"cache": {Image: "redis"},
+	}
+
+	tests := []struct {
+		name      string
+		failure   string
+		wantErr   bool
+		wantLines []string
+	}{
+		{"ready", "", false, []string{
+			"docker network create --label runcontainer=true net",
+			"docker run -d --name net-cache --network net --network-alias cache redis",
+			"docker run -d --name net-db --network net --network-alias db postgres",
+			"docker inspect --format {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}} net-cache",
+			"docker inspect --format {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}} net-db",
+			"docker exec net-db /bin/sh -c pg_isready",
+			"docker inspect --format {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}} net-db",
+			"docker exec net-db /bin/sh -c pg_isready",
+			"docker rm -f -v net-cache net-db",
+			"docker network rm net",
+		}},
+		{"service failure", "run", true, []string{
+			"docker network create --label runcontainer=true net",
+			"docker run -d --name net-cache --network net --network-alias cache redis",
+			"docker network rm net",
+		}},
+		{"network failure", "network", true, []string{
+			"docker network create --label runcontainer=true net",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := 0
+			executor := &FakeExecutor{Handler: func(ctx context.Context, command Command) error {
+				switch command.Args[0] {
+				case "inspect":
+					fmt.Fprint(command.Stdout, "running")
+				case "exec":
+					// The database is ready at the second check
+					if checks++; checks == 1 {
+						return &ExitError{Code: 1}
+					}
+				}
+				if command.Args[0] == tt.failure {
+					return errors.New("failure")
+				}
+				return nil
+			}}
+			run := &serviceRun{network: "net", executor: executor, logger: logrus.New()}
+
+			err := run.start(context.Background(), services)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("start error = %v, want error %v", err, tt.wantErr)
+			}
+			var stderr bytes.Buffer
+			run.stop(&stderr)
+			if stderr.Len() > 0 {
+				t.Errorf("stop failed: %s", stderr.String())
+			}
+			if lines := executor.CommandLines(); !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Errorf("commands =\n%q\nwant\n%q", lines, tt.wantLines)
+			}
+		})
+	}
+}
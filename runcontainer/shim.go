@@ -0,0 +1,173 @@
+package runcontainer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// shimMarker identifies the wrappers written by runcontainer, it is followed by the JSON description of the shim.
+const shimMarker = "runcontainer-shim "
+
+// Shim is a wrapper executable that runs a command in the container of a profile.
+type Shim struct {
+	Name       string `json:"-"`
+	Path       string `json:"-"`
+	Command    string `json:"command"`
+	Profile    string `json:"profile,omitempty"`
+	ConfigFile string `json:"config,omitempty"`
+}
+
+// DefaultShimFolder returns the folder where the shims are installed by default.
+func DefaultShimFolder() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".runcontainer", "bin"), nil
+}
+
+// InstallShim writes the wrapper of the shim in the folder. An existing file is only replaced if it is a shim.
+func InstallShim(folder string, shim Shim) (string, error) {
+	if err := checkShimName(shim.Command); err != nil {
+		return "", err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	fileName := filepath.Join(folder, getShimFileName(shim.Command))
+	if _, err := os.Stat(fileName); err == nil {
+		if _, err := readShim(fileName); err != nil {
+			return "", fmt.Errorf("%s already exists and is not a runcontainer shim", fileName)
+		}
+	}
+
+	description, err := json.Marshal(shim)
+	if err != nil {
+		return "", err
+	}
+
+	var content string
+	if runtime.GOOS == "windows" {
+		args := []string{quoteBatch(executable)}
+		if shim.ConfigFile != "" {
+			args = append(args, "--config", quoteBatch(shim.ConfigFile))
+		}
+		if shim.Profile != "" {
+			args = append(args, "--profile", quoteBatch(shim.Profile))
+		}
+		args = append(args, "--", quoteBatch(shim.Command), "%*")
+		content = fmt.Sprintf("@echo off\r\nrem %s%s\r\n%s\r\nexit /b %%ERRORLEVEL%%\r\n", shimMarker, description, strings.Join(args, " "))
+	} else {
+		args := []string{"exec", quoteShell(executable)}
+		if shim.ConfigFile != "" {
+			args = append(args, "--config", quoteShell(shim.ConfigFile))
+		}
+		if shim.Profile != "" {
+			args = append(args, "--profile", quoteShell(shim.Profile))
+		}
+		args = append(args, "--", quoteShell(shim.Command), `"$@"`)
+		content = fmt.Sprintf("#!/bin/sh\n# %s%s\n%s\n", shimMarker, description, strings.Join(args, " "))
+	}
+
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return "", err
+	}
+	return fileName, ioutil.WriteFile(fileName, []byte(content), 0755)
+}
+
+// ListShims returns the shims installed in the folder.
+func ListShims(folder string) ([]Shim, error) {
+	files, err := ioutil.ReadDir(folder)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var shims []Shim
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if shim, err := readShim(filepath.Join(folder, file.Name())); err == nil {
+			shims = append(shims, *shim)
+		}
+	}
+	sort.Slice(shims, func(i, j int) bool { return shims[i].Name < shims[j].Name })
+	return shims, nil
+}
+
+// RemoveShim deletes the shim of a command, files that are not shims are never deleted.
+func RemoveShim(folder, command string) error {
+	if err := checkShimName(command); err != nil {
+		return err
+	}
+	fileName := filepath.Join(folder, getShimFileName(command))
+	if _, err := readShim(fileName); err != nil {
+		return err
+	}
+	return os.Remove(fileName)
+}
+
+func readShim(fileName string) (*Shim, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The marker is on the second line
+	scanner := bufio.NewScanner(file)
+	for line := 0; line < 2 && scanner.Scan(); line++ {
+		if index := strings.Index(scanner.Text(), shimMarker); index >= 0 {
+			shim := &Shim{}
+			if err := json.Unmarshal([]byte(scanner.Text()[index+len(shimMarker):]), shim); err != nil {
+				return nil, fmt.Errorf("invalid runcontainer shim %s: %v", fileName, err)
+			}
+			shim.Path = fileName
+			shim.Name = strings.TrimSuffix(filepath.Base(fileName), ".cmd")
+			return shim, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a runcontainer shim", fileName)
+}
+
+// checkShimName ensures that the shim of a command is written in the shim folder and not anywhere else.
+func checkShimName(command string) error {
+	if command == "" || strings.ContainsAny(command, `/\`) || strings.Contains(command, "..") {
+		return fmt.Errorf("invalid command name '%s', it must not be empty or contain a path", command)
+	}
+	return nil
+}
+
+func getShimFileName(command string) string {
+	if runtime.GOOS == "windows" {
+		return command + ".cmd"
+	}
+	return command
+}
+
+func quoteShell(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func quoteBatch(value string) string {
+	return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+}
+
+// IsTerminal returns true if the stream is a terminal.
+func IsTerminal(stream interface{}) bool {
+	file, ok := stream.(*os.File)
+	return ok && (isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd()))
+}
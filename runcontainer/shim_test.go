@@ -0,0 +1,33 @@
+package runcontainer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShimName(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "bin")
+	for _, name := range []string{"", "..", "../terraform", "bin/terraform", `bin\terraform`, "/usr/bin/terraform", "terraform..old"} {
+		if _, err := InstallShim(folder, Shim{Command: name}); err == nil {
+			t.Errorf("the shim of %q should be refused", name)
+		}
+		if err := RemoveShim(folder, name); err == nil {
+			t.Errorf("the removal of %q should be refused", name)
+		}
+	}
+	if _, err := os.Stat(folder); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written: %v", err)
+	}
+
+	fileName, err := InstallShim(folder, Shim{Command: "terraform", Profile: "tf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(fileName) != folder {
+		t.Errorf("the shim is written in %s", fileName)
+	}
+	if err := RemoveShim(folder, "terraform"); err != nil {
+		t.Error(err)
+	}
+}
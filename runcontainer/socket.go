@@ -0,0 +1,59 @@
+package runcontainer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// getDockerSocket returns the socket of the daemon running the profile, mounted by with-docker-mount. It is the unix
+// socket of the docker-host or docker-context of the profile, of DOCKER_HOST, DOCKER_CONTEXT or the current docker
+// context in the host environment, or else the first existing default socket (rootful, rootless, then podman).
+func (runner *Runner) getDockerSocket(endpoint dockerEndpoint, environ []string) (string, error) {
+	if runner.dockerSocket != "" {
+		return runner.dockerSocket, nil
+	}
+
+	endpoint, err := endpoint.resolve(environ)
+	if err != nil {
+		return "", err
+	}
+
+	switch host := endpoint.Host; {
+	case host == "" || strings.HasPrefix(host, "npipe://"):
+		// The named pipe of Docker Desktop is the default socket of its VM
+		return findDefaultDockerSocket(environ)
+	case strings.HasPrefix(host, "unix://"):
+		return strings.TrimPrefix(host, "unix://"), nil
+	}
+	return "", fmt.Errorf("with-docker-mount requires a local unix socket, the docker host is %s", endpoint.Host)
+}
+
+// getDockerSocketCandidates returns the sockets looked for when no docker host is configured.
+func getDockerSocketCandidates(environ []string) []string {
+	candidates := []string{dockerSocketFile}
+	if runtimeDir := lookupEnv(environ, "XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, runtimeDir+"/docker.sock", runtimeDir+"/podman/podman.sock")
+	}
+	return append(candidates, "/run/podman/podman.sock")
+}
+
+// findDockerSocket returns the first existing socket.
+func findDockerSocket(candidates []string) (string, error) {
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("with-docker-mount requires a docker socket, none found in %s, set docker-host to unix://<socket>", strings.Join(candidates, ", "))
+}
+
+// lookupEnv returns the value of a variable of an environment, empty if not set.
+func lookupEnv(environ []string, name string) string {
+	for i := len(environ) - 1; i >= 0; i-- {
+		if split := strings.SplitN(environ[i], "=", 2); len(split) == 2 && split[0] == name {
+			return split[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package runcontainer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDockerSocket(t *testing.T) {
+	runner := NewRunner()
+	tests := []struct {
+		name     string
+		endpoint dockerEndpoint
+		environ  []string
+		want     string
+		wantErr  bool
+	}{
+		{"profile host", dockerEndpoint{Host: "unix:///run/user/1000/docker.sock"}, []string{"DOCKER_HOST=unix:///var/run/other.sock"}, "/run/user/1000/docker.sock", false},
+		{"environment host", dockerEndpoint{}, []string{"DOCKER_HOST=unix:///run/podman/podman.sock"}, "/run/podman/podman.sock", false},
+		{"remote host", dockerEndpoint{Host: "tcp://docker.example.com:2376"}, nil, "", true},
+		{"ssh host", dockerEndpoint{}, []string{"DOCKER_HOST=ssh://build@docker.example.com"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runner.getDockerSocket(tt.endpoint, tt.environ)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getDockerSocket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getDockerSocket() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindDockerSocket(t *testing.T) {
+	runtimeDir := filepath.ToSlash(t.TempDir())
+	candidates := getDockerSocketCandidates([]string{"XDG_RUNTIME_DIR=" + runtimeDir})
+	want := []string{dockerSocketFile, runtimeDir + "/docker.sock", runtimeDir + "/podman/podman.sock", "/run/podman/podman.sock"}
+	if len(candidates) != len(want) {
+		t.Fatalf("candidates = %v, want %v", candidates, want)
+	}
+	for i := range want {
+		if candidates[i] != want[i] {
+			t.Fatalf("candidates = %v, want %v", candidates, want)
+		}
+	}
+
+	// The rootless socket is found when there is no rootful daemon
+	if _, err := findDockerSocket(candidates[1:3]); err == nil {
+		t.Error("a missing socket should be an error")
+	}
+	if err := ioutil.WriteFile(candidates[1], nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := findDockerSocket(candidates[1:3]); err != nil || got != candidates[1] {
+		t.Errorf("findDockerSocket() = %s, %v, want %s", got, err, candidates[1])
+	}
+}
@@ -0,0 +1,126 @@
+package runcontainer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reTaskArgs matches the references to the positional parameters in a task command.
+var reTaskArgs = regexp.MustCompile(`\$(\{)?[@*1-9]`)
+
+// Task is a named command run in the profile container. The command is run with /bin/sh in place of the image entry
+// point. The task arguments are appended to the command, unless it refers to them itself ("$@", "$1", ...), which is
+// required to give them to a command that is not the last one of a compound command (i.e. make "$@" && make install).
+// A task without command only runs its dependencies.
+type Task struct {
+	Command     string            `yaml:"command,omitempty" json:"command,omitempty" hcl:"command,omitempty"`
+	Description string            `yaml:"description,omitempty" json:"description,omitempty" hcl:"description,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty" json:"environment,omitempty" hcl:"environment,omitempty"`
+	DependsOn   []string          `yaml:"depends-on,omitempty" json:"depends-on,omitempty" hcl:"depends-on,omitempty"`
+}
+
+// GetTaskNames returns the sorted names of the profile tasks.
+func (config *DockerConfig) GetTaskNames() []string {
+	names := make([]string, 0, len(config.Tasks))
+	for name := range config.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetTaskOrder returns the tasks to run in order to run a task, dependencies first.
+func (config *DockerConfig) GetTaskOrder(name string) ([]string, error) {
+	var order []string
+	visited := map[string]bool{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if listContainsElement(path, name) {
+			return fmt.Errorf("circular task dependency: %s", strings.Join(append(path, name), " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		task := config.Tasks[name]
+		if task == nil {
+			if len(path) > 0 {
+				return fmt.Errorf("task %s (required by %s) not found", name, path[len(path)-1])
+			}
+			return fmt.Errorf("task %s not found", name)
+		}
+		for _, dependency := range task.DependsOn {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		order = append(order, name)
+		return nil
+	}
+
+	if err := visit(name, nil); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// RunTask runs the dependencies of a task and then the task itself with the supplied args, using a runner configured
+// by the options. It stops at the first failing task and returns its exit code.
+func (config *DockerConfig) RunTask(name string, args []string, options ...Option) int {
+	stderr := NewRunner(options...).streams.Err
+	order, err := config.GetTaskOrder(name)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	for _, taskName := range order {
+		task := config.Tasks[taskName]
+		if task.Command == "" {
+			continue
+		}
+
+		taskConfig := config.clone()
+		for key, value := range task.Environment {
+			taskConfig.Environment[key] = value
+		}
+		taskConfig.Environment["RUNCONTAINER_TASK"] = taskName
+
+		var taskArgs []string
+		if taskName == name {
+			taskArgs = args
+		}
+
+		// The shell replaces the image entry point, which would otherwise receive the shell as argument
+		taskConfig.DockerOptions = append(append([]string(nil), config.DockerOptions...), "--entrypoint", "/bin/sh")
+		if exitCode := taskConfig.ExecuteWithOptions(getTaskCommand(taskName, task, taskArgs), options...); exitCode != 0 {
+			if taskName != name {
+				fmt.Fprintf(stderr, "Task %s failed with exit code %d, %s is not run\n", taskName, exitCode, name)
+			}
+			return exitCode
+		}
+	}
+	return 0
+}
+
+// getTaskCommand returns the arguments given to the /bin/sh entry point to run a task.
+func getTaskCommand(name string, task *Task, args []string) []string {
+	script := task.Command
+	if !reTaskArgs.MatchString(script) {
+		script += ` "$@"`
+	}
+	return append([]string{"-c", script, name}, args...)
+}
+
+// clone returns a copy of the configuration that can be altered (i.e. its environment) without affecting the original.
+func (config *DockerConfig) clone() *DockerConfig {
+	clone := *config
+	clone.Environment = make(map[string]string, len(config.Environment))
+	for key, value := range config.Environment {
+		clone.Environment[key] = value
+	}
+	return &clone
+}
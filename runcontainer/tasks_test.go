@@ -0,0 +1,113 @@
+package runcontainer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetTaskOrder(t *testing.T) {
+	config := &DockerConfig{Tasks: map[string]*Task{
+		"init":    {Command: "terraform init"},
+		"lint":    {Command: "tflint"},
+		"plan":    {Command: "terraform plan", DependsOn: []string{"init"}},
+		"check":   {DependsOn: []string{"lint", "plan"}},
+		"apply":   {Command: "terraform apply", DependsOn: []string{"plan", "init"}},
+		"release": {DependsOn: []string{"check", "apply"}},
+		"missing": {DependsOn: []string{"lint", "unknown"}},
+		"self":    {DependsOn: []string{"self"}},
+		"ping":    {DependsOn: []string{"init", "pong"}},
+		"pong":    {DependsOn: []string{"ping"}},
+	}}
+
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr string
+	}{
+		{"init", []string{"init"}, ""},
+		{"plan", []string{"init", "plan"}, ""},
+		{"check", []string{"lint", "init", "plan", "check"}, ""},
+		{"apply", []string{"init", "plan", "apply"}, ""},
+		{"release", []string{"lint", "init", "plan", "check", "apply", "release"}, ""},
+		{"unknown", nil, "task unknown not found"},
+		{"missing", nil, "task unknown (required by missing) not found"},
+		{"self", nil, "circular task dependency: self -> self"},
+		{"ping", nil, "circular task dependency: ping -> pong -> ping"},
+		{"pong", nil, "circular task dependency: pong -> ping -> pong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := config.GetTaskOrder(tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTaskCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+	}{
+		{"simple", "terraform plan", []string{"-c", `terraform plan "$@"`, "simple", "-out", "plan"}},
+		{"compound", `make "$@" && make install`, []string{"-c", `make "$@" && make install`, "compound", "-out", "plan"}},
+		{"positional", "echo ${1} $2", []string{"-c", "echo ${1} $2", "positional", "-out", "plan"}},
+		{"all", "echo $*; ls", []string{"-c", "echo $*; ls", "all", "-out", "plan"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := getTaskCommand(tt.name, &Task{Command: tt.command}, []string{"-out", "plan"})
+			if !reflect.DeepEqual(command, tt.want) {
+				t.Errorf("command = %q, want %q", command, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTaskErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantExitCode int
+		wantErr      string
+	}{
+		{"unknown", 1, "task unknown not found\n"},
+		{"plan", 3, "Task init failed with exit code 3, plan is not run\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newTestRun(t)
+			run.executor.Handler = func(ctx context.Context, command Command) error {
+				if command.Name == "docker" && command.Args[0] == "start" {
+					return &ExitError{Code: 3}
+				}
+				return nil
+			}
+			profile := run.profile()
+			profile.TempDirMountLocation = MountLocNone
+			profile.Tasks = map[string]*Task{
+				"init": {Command: "terraform init"},
+				"plan": {Command: "terraform plan", DependsOn: []string{"init"}},
+			}
+
+			if exitCode := profile.RunTask(tt.name, nil, run.options()...); exitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d", exitCode, tt.wantExitCode)
+			}
+			if run.err.String() != tt.wantErr {
+				t.Errorf("stderr = %q, want %q", run.err.String(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package runcontainer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Phases of a run
+const (
+	PhaseConfig   = "config"
+	PhaseLock     = "lock"
+	PhaseImage    = "image"
+	PhaseHooks    = "hooks"
+	PhaseServices = "services"
+	PhaseCreate   = "create"
+	PhaseTransfer = "transfer"
+	PhaseStart    = "start"
+	PhaseRun      = "run"
+	PhaseCleanup  = "cleanup"
+)
+
+// Timing is the time spent in a phase of a run.
+type Timing struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// Timings are the phases of a run in the order they were first entered.
+type Timings []Timing
+
+// Add adds a duration to a phase.
+func (timings *Timings) Add(phase string, duration time.Duration) {
+	for i := range *timings {
+		if (*timings)[i].Phase == phase {
+			(*timings)[i].Duration += duration
+			return
+		}
+	}
+	*timings = append(*timings, Timing{Phase: phase, Duration: duration})
+}
+
+// Total returns the time spent in all the phases.
+func (timings Timings) Total() (total time.Duration) {
+	for _, timing := range timings {
+		total += timing.Duration
+	}
+	return
+}
+
+// MetricsFormat is the format of a metrics file.
+type MetricsFormat string
+
+// Metrics formats
+const (
+	MetricsPrometheus  MetricsFormat = "prometheus"
+	MetricsOpenMetrics MetricsFormat = "openmetrics"
+)
+
+// WriteMetrics writes the timings and exit codes of the runs in a Prometheus textfile or an OpenMetrics file. The file
+// is replaced atomically so a collector never reads a partial file.
+func WriteMetrics(fileName string, format MetricsFormat, results []Result) error {
+	if format != MetricsPrometheus && format != MetricsOpenMetrics {
+		return fmt.Errorf("unknown metrics format %s, expected %s or %s", format, MetricsPrometheus, MetricsOpenMetrics)
+	}
+
+	var content bytes.Buffer
+	writeFamily := func(name, help, unit string, samples func()) {
+		fmt.Fprintf(&content, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		if format == MetricsOpenMetrics && unit != "" {
+			fmt.Fprintf(&content, "# UNIT %s %s\n", name, unit)
+		}
+		samples()
+	}
+
+	writeFamily("runcontainer_phase_duration_seconds", "Time spent in each phase of the last run.", "seconds", func() {
+		for _, result := range results {
+			for _, timing := range result.Timings {
+				fmt.Fprintf(&content, `runcontainer_phase_duration_seconds{%s,phase="%s"} %g`+"\n", getMetricLabels(result), timing.Phase, timing.Duration.Seconds())
+			}
+		}
+	})
+	writeFamily("runcontainer_run_duration_seconds", "Total duration of the last run.", "seconds", func() {
+		for _, result := range results {
+			fmt.Fprintf(&content, "runcontainer_run_duration_seconds{%s} %g\n", getMetricLabels(result), result.Timings.Total().Seconds())
+		}
+	})
+	writeFamily("runcontainer_exit_code", "Exit code of the last run.", "", func() {
+		for _, result := range results {
+			fmt.Fprintf(&content, "runcontainer_exit_code{%s} %d\n", getMetricLabels(result), result.ExitCode)
+		}
+	})
+	writeFamily("runcontainer_last_run_timestamp_seconds", "Time the last run started.", "seconds", func() {
+		for _, result := range results {
+			fmt.Fprintf(&content, "runcontainer_last_run_timestamp_seconds{%s} %d\n", getMetricLabels(result), result.Started.Unix())
+		}
+	})
+	if format == MetricsOpenMetrics {
+		content.WriteString("# EOF\n")
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content.Bytes()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), fileName)
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func getMetricLabels(result Result) string {
+	return fmt.Sprintf(`profile="%s",image="%s",run="%s"`,
+		metricLabelEscaper.Replace(result.Profile), metricLabelEscaper.Replace(result.Image), metricLabelEscaper.Replace(result.Run))
+}
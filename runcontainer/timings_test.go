@@ -0,0 +1,138 @@
+package runcontainer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stepClock advances by one second every time it is read.
+type stepClock struct{ now time.Time }
+
+func (clock *stepClock) Now() time.Time {
+	clock.now = clock.now.Add(time.Second)
+	return clock.now
+}
+
+func TestRunnerTimings(t *testing.T) {
+	run := newTestRun(t, WithClock(&stepClock{}))
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		if command.Args[0] == "inspect" {
+			// The command ran for 300ms of the second spent in docker start
+			fmt.Fprint(command.Stdout, "2021-07-01T00:00:00.2Z 2021-07-01T00:00:00.5Z")
+		}
+		return nil
+	}
+
+	result, err := run.runner.Run(context.Background(), run.profile(), []string{"true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Timings{
+		{PhaseImage, time.Second},
+		{PhaseHooks, 2 * time.Second},
+		{PhaseCreate, time.Second},
+		{PhaseStart, 700 * time.Millisecond},
+		{PhaseRun, 300 * time.Millisecond},
+		{PhaseCleanup, time.Second},
+	}
+	if fmt.Sprint(result.Timings) != fmt.Sprint(want) {
+		t.Errorf("timings = %v, want %v", result.Timings, want)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "runcontainer.prom")
+	results := []Result{{
+		Profile:  "iac",
+		Image:    "alpine:3.14",
+		Run:      `iac TF="1"`,
+		ExitCode: 2,
+		Started:  time.Unix(1625097600, 0),
+		Timings:  Timings{{PhaseCreate, 1500 * time.Millisecond}, {PhaseRun, 2 * time.Second}},
+	}}
+
+	for _, format := range []MetricsFormat{MetricsPrometheus, MetricsOpenMetrics} {
+		if err := WriteMetrics(fileName, format, results); err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, line := range []string{
+			`runcontainer_phase_duration_seconds{profile="iac",image="alpine:3.14",run="iac TF=\"1\"",phase="create"} 1.5`,
+			`runcontainer_run_duration_seconds{profile="iac",image="alpine:3.14",run="iac TF=\"1\""} 3.5`,
+			`runcontainer_exit_code{profile="iac",image="alpine:3.14",run="iac TF=\"1\""} 2`,
+			`runcontainer_last_run_timestamp_seconds{profile="iac",image="alpine:3.14",run="iac TF=\"1\""} 1625097600`,
+		} {
+			if !strings.Contains(string(content), line+"\n") {
+				t.Errorf("%s metrics do not contain %s:\n%s", format, line, content)
+			}
+		}
+		if hasEOF := strings.HasSuffix(string(content), "# EOF\n"); hasEOF != (format == MetricsOpenMetrics) {
+			t.Errorf("%s metrics end with # EOF: %v", format, hasEOF)
+		}
+	}
+
+	if err := WriteMetrics(fileName, "csv", results); err == nil {
+		t.Error("an unknown format should be rejected")
+	}
+}
+
+const wantOpenMetrics = `# HELP runcontainer_phase_duration_seconds Time spent in each phase of the last run.
+# TYPE runcontainer_phase_duration_seconds gauge
+# UNIT runcontainer_phase_duration_seconds seconds
+runcontainer_phase_duration_seconds{profile="iac",image="alpine:3.14",run="plan",phase="create"} 1.5
+runcontainer_phase_duration_seconds{profile="iac",image="alpine:3.14",run="plan",phase="run"} 2
+runcontainer_phase_duration_seconds{profile="docs",image="python:3",run="",phase="run"} 0.25
+# HELP runcontainer_run_duration_seconds Total duration of the last run.
+# TYPE runcontainer_run_duration_seconds gauge
+# UNIT runcontainer_run_duration_seconds seconds
+runcontainer_run_duration_seconds{profile="iac",image="alpine:3.14",run="plan"} 3.5
+runcontainer_run_duration_seconds{profile="docs",image="python:3",run=""} 0.25
+# HELP runcontainer_exit_code Exit code of the last run.
+# TYPE runcontainer_exit_code gauge
+runcontainer_exit_code{profile="iac",image="alpine:3.14",run="plan"} 2
+runcontainer_exit_code{profile="docs",image="python:3",run=""} 0
+# HELP runcontainer_last_run_timestamp_seconds Time the last run started.
+# TYPE runcontainer_last_run_timestamp_seconds gauge
+# UNIT runcontainer_last_run_timestamp_seconds seconds
+runcontainer_last_run_timestamp_seconds{profile="iac",image="alpine:3.14",run="plan"} 1625097600
+runcontainer_last_run_timestamp_seconds{profile="docs",image="python:3",run=""} 1625097660
+# EOF
+`
+
+func TestWriteOpenMetrics(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "runcontainer.om")
+	results := []Result{{
+		Profile:  "iac",
+		Image:    "alpine:3.14",
+		Run:      "plan",
+		ExitCode: 2,
+		Started:  time.Unix(1625097600, 0),
+		Timings:  Timings{{PhaseCreate, 1500 * time.Millisecond}, {PhaseRun, 2 * time.Second}},
+	}, {
+		Profile: "docs",
+		Image:   "python:3",
+		Started: time.Unix(1625097660, 0),
+		Timings: Timings{{PhaseRun, 250 * time.Millisecond}},
+	}}
+
+	if err := WriteMetrics(fileName, MetricsOpenMetrics, results); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != wantOpenMetrics {
+		t.Errorf("metrics =\n%s\nwant\n%s", content, wantOpenMetrics)
+	}
+}
@@ -0,0 +1,381 @@
+package runcontainer
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/fileutils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TransferMode defines how the workspace is made available to the container
+type TransferMode string
+
+// Workspace transfer modes
+const (
+	// TransferBind mounts the workspace in the container (default)
+	TransferBind TransferMode = "bind"
+	// TransferCopy copies the workspace into the container and copies the files added or changed by the command back
+	TransferCopy TransferMode = "copy"
+	// TransferSync is like TransferCopy but also deletes the files deleted by the command
+	TransferSync TransferMode = "sync"
+)
+
+// transferIgnoreFile contains the patterns (as in .dockerignore) of the workspace files that are not copied
+const transferIgnoreFile = ".runcontainerignore"
+
+// conflictSuffix is added to the name of the container version of a file changed on both sides
+const conflictSuffix = ".runcontainer"
+
+// isWorkspaceCopied returns true if the workspace is copied instead of being mounted.
+func (config *DockerConfig) isWorkspaceCopied() (bool, error) {
+	switch config.WorkspaceTransfer {
+	case "", TransferBind:
+		return false, nil
+	case TransferCopy, TransferSync:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown workspace-transfer '%s', expected %s, %s or %s", config.WorkspaceTransfer, TransferBind, TransferCopy, TransferSync)
+}
+
+// TransferSummary lists the workspace files (relative slash separated paths) changed by the command.
+type TransferSummary struct {
+	Added   []string
+	Updated []string
+	Deleted []string
+	// Conflicts are the files changed both by the command and on the host during the run, the host version is kept
+	// and the container version is saved next to it with the .runcontainer suffix
+	Conflicts []string
+}
+
+// Print writes the changed files and the number of changes.
+func (summary TransferSummary) Print(out io.Writer) {
+	for _, change := range []struct {
+		status string
+		files  []string
+	}{{"A", summary.Added}, {"M", summary.Updated}, {"D", summary.Deleted}, {"C", summary.Conflicts}} {
+		for _, file := range change.files {
+			fmt.Fprintf(out, "%s %s\n", change.status, file)
+		}
+	}
+	fmt.Fprintf(out, "Workspace: %d added, %d updated, %d deleted, %d conflicts\n", len(summary.Added), len(summary.Updated), len(summary.Deleted), len(summary.Conflicts))
+}
+
+// workspaceTransfer copies a workspace into a container and back.
+type workspaceTransfer struct {
+	mode        TransferMode
+	hostFolder  string
+	mountFolder string
+	ignore      *fileutils.PatternMatcher
+	// uploaded are the hashes of the files copied into the container, by relative path
+	uploaded map[string]string
+}
+
+func newWorkspaceTransfer(mode TransferMode, hostFolder, mountFolder string) (*workspaceTransfer, error) {
+	patterns, err := readIgnorePatterns(filepath.Join(hostFolder, transferIgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", transferIgnoreFile, err)
+	}
+	return &workspaceTransfer{mode: mode, hostFolder: hostFolder, mountFolder: mountFolder, ignore: ignore, uploaded: map[string]string{}}, nil
+}
+
+// readIgnorePatterns reads the patterns of an ignore file, it is not an error if the file does not exist.
+func readIgnorePatterns(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+func (transfer *workspaceTransfer) isIgnored(relative string) bool {
+	ignored, err := transfer.ignore.Matches(relative)
+	return err == nil && ignored
+}
+
+// upload copies the workspace into the created container.
+func (transfer *workspaceTransfer) upload(ctx context.Context, cli client.APIClient, container string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(transfer.writeArchive(writer))
+	}()
+	err := cli.CopyToContainer(ctx, container, "/", reader, types.CopyToContainerOptions{})
+	reader.CloseWithError(err)
+	return err
+}
+
+// writeArchive writes the workspace as a tar archive rooted at / with the files in the mount folder.
+func (transfer *workspaceTransfer) writeArchive(out io.Writer) error {
+	archive := tar.NewWriter(out)
+	root := strings.Trim(transfer.mountFolder, "/")
+	parts := strings.Split(root, "/")
+	for i := range parts {
+		if err := archive.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: strings.Join(parts[:i+1], "/") + "/", Mode: 0755}); err != nil {
+			return err
+		}
+	}
+
+	err := filepath.Walk(transfer.hostFolder, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(transfer.hostFolder, fileName)
+		if err != nil || relative == "." {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+		if transfer.isIgnored(relative) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(fileName); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(root, relative)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(archive, hash), file); err != nil {
+			return err
+		}
+		transfer.uploaded[relative] = fmt.Sprintf("%x", hash.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// download copies the files added or changed in the container back to the workspace (and deletes the files deleted
+// in the container in sync mode). The files changed on the host since the upload are not overwritten.
+func (transfer *workspaceTransfer) download(ctx context.Context, cli client.APIClient, container string) (TransferSummary, error) {
+	var summary TransferSummary
+	content, _, err := cli.CopyFromContainer(ctx, container, transfer.mountFolder)
+	if err != nil {
+		return summary, err
+	}
+	defer content.Close()
+
+	// The archive is written by the container, it must not be able to write outside of the host folder
+	root, err := filepath.EvalSymlinks(transfer.hostFolder)
+	if err != nil {
+		return summary, err
+	}
+
+	found := map[string]bool{}
+	archive := tar.NewReader(content)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		// The entries are in a folder named like the mount folder
+		split := strings.SplitN(strings.TrimSuffix(header.Name, "/"), "/", 2)
+		if len(split) < 2 || transfer.isIgnored(split[1]) {
+			continue
+		}
+		relative := split[1]
+		if listContainsElement(strings.Split(relative, "/"), "..") {
+			return summary, fmt.Errorf("invalid path %s in the container archive", header.Name)
+		}
+		found[relative] = true
+		fileName := filepath.Join(transfer.hostFolder, filepath.FromSlash(relative))
+		if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeReg {
+			if err := checkHostPath(root, fileName); err != nil {
+				return summary, err
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(fileName, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return summary, err
+			}
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(target) || path.IsAbs(header.Linkname) || !isPathWithin(filepath.Join(filepath.Dir(fileName), target), transfer.hostFolder) {
+				return summary, fmt.Errorf("symbolic link %s points outside of the workspace: %s", relative, header.Linkname)
+			}
+			if _, err := os.Lstat(fileName); os.IsNotExist(err) {
+				if err := os.Symlink(header.Linkname, fileName); err != nil {
+					return summary, err
+				}
+				summary.Added = append(summary.Added, relative)
+			}
+		case tar.TypeReg:
+			if err := transfer.downloadFile(archive, header, relative, fileName, &summary); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	if transfer.mode == TransferSync {
+		for relative, uploadedHash := range transfer.uploaded {
+			if found[relative] {
+				continue
+			}
+			fileName := filepath.Join(transfer.hostFolder, filepath.FromSlash(relative))
+			if hostHash, _ := hashFile(fileName); hostHash != uploadedHash {
+				if hostHash != "" {
+					summary.Conflicts = append(summary.Conflicts, relative)
+				}
+				continue
+			}
+			if err := checkHostPath(root, fileName); err != nil {
+				return summary, err
+			}
+			if err := os.Remove(fileName); err != nil {
+				return summary, err
+			}
+			summary.Deleted = append(summary.Deleted, relative)
+		}
+	}
+
+	for _, files := range [][]string{summary.Added, summary.Updated, summary.Deleted, summary.Conflicts} {
+		sort.Strings(files)
+	}
+	return summary, nil
+}
+
+func (transfer *workspaceTransfer) downloadFile(archive io.Reader, header *tar.Header, relative, fileName string, summary *TransferSummary) error {
+	// The file is written in a temporary file first, to only replace the host file if it has been changed
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	temp, err := os.OpenFile(fileName+conflictSuffix+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(temp, hash), archive)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	containerHash := fmt.Sprintf("%x", hash.Sum(nil))
+	uploadedHash, uploaded := transfer.uploaded[relative]
+	if containerHash == uploadedHash {
+		return nil
+	}
+	hostHash, err := hashFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hostHash == containerHash:
+		// The host has the same change
+		return nil
+	case hostHash != uploadedHash:
+		// The file has been changed (or created) on the host meanwhile
+		summary.Conflicts = append(summary.Conflicts, relative)
+		return os.Rename(temp.Name(), fileName+conflictSuffix)
+	case uploaded:
+		summary.Updated = append(summary.Updated, relative)
+	default:
+		summary.Added = append(summary.Added, relative)
+	}
+	return os.Rename(temp.Name(), fileName)
+}
+
+// checkHostPath ensures that a file written by the download is in the host folder (root, with its symbolic links
+// resolved), the closest existing folder of the file may be a symbolic link pointing elsewhere.
+func checkHostPath(root, fileName string) error {
+	folder := filepath.Dir(fileName)
+	for {
+		if _, err := os.Lstat(folder); err == nil || filepath.Dir(folder) == folder {
+			break
+		}
+		folder = filepath.Dir(folder)
+	}
+	resolved, err := filepath.EvalSymlinks(folder)
+	if err != nil {
+		return err
+	}
+	if !isPathWithin(resolved, root) {
+		return fmt.Errorf("%s is outside of the workspace %s", fileName, root)
+	}
+	return nil
+}
+
+// isPathWithin returns true if the file is the folder or one of its descendants.
+func isPathWithin(fileName, folder string) bool {
+	relative, err := filepath.Rel(folder, fileName)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// hashFile returns the hash of a file content, or an empty string if it does not exist.
+func hashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
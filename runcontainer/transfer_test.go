@@ -0,0 +1,199 @@
+package runcontainer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"github.com/docker/docker/api/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// transferClient is a docker client recording the uploaded archive and returning a prepared one.
+type transferClient struct {
+	fakeClient
+	uploaded   map[string]string
+	downloaded []byte
+}
+
+func (cli *transferClient) CopyToContainer(ctx context.Context, container, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	cli.uploaded = map[string]string{}
+	archive := tar.NewReader(content)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return err
+		}
+		cli.uploaded[header.Name] = string(data)
+	}
+}
+
+func (cli *transferClient) CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	return ioutil.NopCloser(bytes.NewReader(cli.downloaded)), types.ContainerPathStat{}, nil
+}
+
+func writeTestFiles(t *testing.T, folder string, files map[string]string) {
+	for name, content := range files {
+		fileName := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWorkspaceTransfer(t *testing.T) {
+	folder := t.TempDir()
+	writeTestFiles(t, folder, map[string]string{
+		transferIgnoreFile:    "# Not needed in the container\n.terraform\n",
+		"main.tf":             "main",
+		"outputs.tf":          "outputs",
+		"removed.tf":          "removed",
+		"conflict.tf":         "conflict",
+		"modules/vpc/main.tf": "vpc",
+		".terraform/plugin":   "plugin",
+	})
+
+	transfer, err := newWorkspaceTransfer(TransferSync, folder, "/src/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &transferClient{}
+	if err := transfer.upload(context.Background(), cli, "container"); err != nil {
+		t.Fatal(err)
+	}
+	wantUploaded := map[string]string{
+		"src/": "", "src/project/": "", "src/project/modules/": "", "src/project/modules/vpc/": "",
+		"src/project/" + transferIgnoreFile: "# Not needed in the container\n.terraform\n",
+		"src/project/main.tf":               "main",
+		"src/project/outputs.tf":            "outputs",
+		"src/project/removed.tf":            "removed",
+		"src/project/conflict.tf":           "conflict",
+		"src/project/modules/vpc/main.tf":   "vpc",
+	}
+	if !reflect.DeepEqual(cli.uploaded, wantUploaded) {
+		t.Errorf("uploaded = %v, want %v", cli.uploaded, wantUploaded)
+	}
+
+	// The command changes main.tf and conflict.tf, creates a plan and deletes removed.tf and outputs.tf, while
+	// conflict.tf and outputs.tf are changed on the host
+	writeTestFiles(t, folder, map[string]string{"conflict.tf": "host", "outputs.tf": "host"})
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"project/" + transferIgnoreFile: "# Not needed in the container\n.terraform\n",
+		"project/main.tf":               "changed",
+		"project/conflict.tf":           "container",
+		"project/modules/vpc/main.tf":   "vpc",
+		"project/plan.out":              "plan",
+		"project/.terraform/plugin":     "new plugin",
+	} {
+		if err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	writer.Close()
+	cli.downloaded = archive.Bytes()
+
+	summary, err := transfer.download(context.Background(), cli, "container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSummary := TransferSummary{Added: []string{"plan.out"}, Updated: []string{"main.tf"}, Deleted: []string{"removed.tf"}, Conflicts: []string{"conflict.tf", "outputs.tf"}}
+	if !reflect.DeepEqual(summary, wantSummary) {
+		t.Errorf("summary = %+v, want %+v", summary, wantSummary)
+	}
+
+	for name, want := range map[string]string{
+		"main.tf":                      "changed",
+		"plan.out":                     "plan",
+		"outputs.tf":                   "host",
+		"conflict.tf":                  "host",
+		"conflict.tf" + conflictSuffix: "container",
+		".terraform/plugin":            "plugin",
+		"removed.tf":                   "",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(folder, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			content = nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("%s = %q, want %q", name, content, want)
+		}
+	}
+}
+
+func TestWorkspaceTransferHostileArchive(t *testing.T) {
+	type entry struct {
+		name, link, content string
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		wantErr bool
+	}{
+		{"parent folder", []entry{{name: "project/../escape"}}, true},
+		{"nested parent folder", []entry{{name: "project/modules/../../escape"}}, true},
+		{"relative link", []entry{{name: "project/escape", link: "../outside"}}, true},
+		{"absolute link", []entry{{name: "project/escape", link: "/etc"}}, true},
+		{"write through host link", []entry{{name: "project/host-link/escape"}}, true},
+		{"folder through host link", []entry{{name: "project/host-link/folder/"}}, true},
+		{"link in workspace", []entry{{name: "project/modules/current", link: "../main.tf"}, {name: "project/inner", link: "modules"}, {name: "project/inner/file"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder, outside := t.TempDir(), t.TempDir()
+			writeTestFiles(t, folder, map[string]string{"main.tf": "main", "modules/vpc/main.tf": "vpc"})
+			if err := os.Symlink(outside, filepath.Join(folder, "host-link")); err != nil {
+				t.Fatal(err)
+			}
+			transfer, err := newWorkspaceTransfer(TransferCopy, folder, "/src/project")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var archive bytes.Buffer
+			writer := tar.NewWriter(&archive)
+			for _, entry := range tt.entries {
+				header := &tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Mode: 0644, Size: int64(len(entry.content))}
+				if entry.link != "" {
+					header = &tar.Header{Typeflag: tar.TypeSymlink, Name: entry.name, Linkname: entry.link, Mode: 0777}
+				} else if entry.name[len(entry.name)-1] == '/' {
+					header = &tar.Header{Typeflag: tar.TypeDir, Name: entry.name, Mode: 0755}
+				}
+				if err := writer.WriteHeader(header); err != nil {
+					t.Fatal(err)
+				}
+				writer.Write([]byte(entry.content))
+			}
+			writer.Close()
+
+			_, err = transfer.download(context.Background(), &transferClient{downloaded: archive.Bytes()}, "container")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if files, _ := ioutil.ReadDir(outside); len(files) != 0 {
+				t.Errorf("%d files written outside of the workspace", len(files))
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(folder), "escape")); !os.IsNotExist(err) {
+				t.Errorf("file written next to the workspace: %v", err)
+			}
+		})
+	}
+}
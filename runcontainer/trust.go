@@ -0,0 +1,144 @@
+package runcontainer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TrustedSettings are the settings of a profile that can run commands on the host or grant access to it. They must be
+// approved by the user before a configuration file is used.
+type TrustedSettings struct {
+	RunBeforeCommands  []string                  `json:"run-before-commands,omitempty"`
+	RunAfterCommands   []string                  `json:"run-after-commands,omitempty"`
+	Hooks              *Hooks                    `json:"hooks,omitempty"`
+	WithDockerMount    bool                      `json:"with-docker-mount,omitempty"`
+	MountHomeDirectory bool                      `json:"mount-home-directory,omitempty"`
+	DockerOptions      []string                  `json:"docker-options,omitempty"`
+	Services           map[string]*ServiceConfig `json:"services,omitempty"`
+	DockerHost         string                    `json:"docker-host,omitempty"`
+	DockerContext      string                    `json:"docker-context,omitempty"`
+	DockerCertPath     string                    `json:"docker-cert-path,omitempty"`
+	// Environment is also set for the hooks and the docker commands (i.e. PATH, BASH_ENV or DOCKER_HOST)
+	Environment map[string]string `json:"environment,omitempty"`
+	// Security only contains the settings weakening the isolation of the container
+	Security *SecurityConfig `json:"security,omitempty"`
+}
+
+// GetTrustedSettings returns the security sensitive settings of each profile defining some.
+func (configs *DockerConfigs) GetTrustedSettings() map[string]TrustedSettings {
+	result := map[string]TrustedSettings{}
+	for name, config := range configs.Configs {
+		if config == nil {
+			continue
+		}
+		settings := TrustedSettings{
+			RunBeforeCommands:  config.RunBeforeCommands,
+			RunAfterCommands:   config.RunAfterCommands,
+			Hooks:              config.Hooks,
+			WithDockerMount:    config.WithDockerMount,
+			MountHomeDirectory: config.MountHomeDirectory,
+			DockerOptions:      config.DockerOptions,
+			Services:           config.Services,
+			DockerHost:         config.DockerHost,
+			DockerContext:      config.DockerContext,
+			DockerCertPath:     config.DockerCertPath,
+			Environment:        config.Environment,
+			Security:           config.Security.getTrustedSettings(),
+		}
+		if content, _ := json.Marshal(settings); string(content) != "{}" {
+			result[name] = settings
+		}
+	}
+	return result
+}
+
+// RequiresTrust returns true if the configuration contains settings that must be approved by the user.
+func (configs *DockerConfigs) RequiresTrust() bool {
+	return len(configs.GetTrustedSettings()) > 0
+}
+
+// Fingerprint returns a hash of the security sensitive settings of the configuration.
+func (configs *DockerConfigs) Fingerprint() string {
+	// Maps are serialized with sorted keys, so the result is stable
+	content, _ := json.Marshal(configs.GetTrustedSettings())
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// TrustStore keeps the fingerprint of the configuration files trusted by the user.
+type TrustStore struct {
+	path  string
+	Files map[string]string `json:"files"`
+}
+
+// DefaultTrustStorePath returns the location of the user trust store.
+func DefaultTrustStorePath() (string, error) {
+	configFolder, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configFolder, "runcontainer", "trust.json"), nil
+}
+
+// LoadTrustStore reads the trust store, a missing file is considered as an empty store.
+func LoadTrustStore(path string) (*TrustStore, error) {
+	store := &TrustStore{path: path, Files: map[string]string{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, fmt.Errorf("unable to read trust store %s: %v", path, err)
+	}
+	if store.Files == nil {
+		store.Files = map[string]string{}
+	}
+	return store, nil
+}
+
+// Save writes the trust store.
+func (store *TrustStore) Save() error {
+	content, err := json.MarshalIndent(store, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, content, 0600)
+}
+
+// IsTrusted returns true if the configuration file has been trusted and its sensitive settings did not change since.
+func (store *TrustStore) IsTrusted(fileName string, configs *DockerConfigs) bool {
+	fingerprint, found := store.Files[getTrustKey(fileName)]
+	return found && fingerprint == configs.Fingerprint()
+}
+
+// Trust records the current sensitive settings of the configuration file as trusted.
+func (store *TrustStore) Trust(fileName string, configs *DockerConfigs) {
+	store.Files[getTrustKey(fileName)] = configs.Fingerprint()
+}
+
+// Untrust removes the configuration file from the store and returns false if it was not there.
+func (store *TrustStore) Untrust(fileName string) bool {
+	key := getTrustKey(fileName)
+	_, found := store.Files[key]
+	delete(store.Files, key)
+	return found
+}
+
+func getTrustKey(fileName string) string {
+	if absolute, err := filepath.Abs(fileName); err == nil {
+		fileName = absolute
+	}
+	if resolved, err := filepath.EvalSymlinks(fileName); err == nil {
+		fileName = resolved
+	}
+	return filepath.ToSlash(fileName)
+}
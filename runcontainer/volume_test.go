@@ -0,0 +1,182 @@
+package runcontainer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// volumeClient is a docker client knowing some volumes.
+type volumeClient struct {
+	fakeClient
+	volumes []*types.Volume
+	removed []string
+}
+
+func (cli *volumeClient) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	return types.DiskUsage{Volumes: cli.volumes}, nil
+}
+
+func (cli *volumeClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	cli.removed = append(cli.removed, volumeID)
+	return nil
+}
+
+func TestRunnerVolumes(t *testing.T) {
+	cli := &volumeClient{volumes: []*types.Volume{
+		{Name: "tgf-jdoe", Driver: "local", UsageData: &types.VolumeUsageData{Size: 2048, RefCount: 0}},
+		{Name: "postgres-data", Driver: "local"},
+		{Name: "tgf", Driver: "local", CreatedAt: "2021-06-01T10:00:00Z"},
+	}}
+	run := newTestRun(t, WithClient(cli))
+
+	// The run records the use of the volumes it mounts
+	profile := run.profile()
+	profile.TempDirMountLocation = MountLocVolume
+	if _, err := run.runner.Run(context.Background(), profile, []string{"true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := run.runner.ListVolumes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUse := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	want := []VolumeInfo{
+		{Name: "tgf", Driver: "local", Created: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), Size: -1, Containers: -1, LastUsed: lastUse},
+		{Name: "tgf-jdoe", Driver: "local", Size: 2048, Containers: 0, LastUsed: lastUse},
+	}
+	if !reflect.DeepEqual(volumes, want) {
+		t.Errorf("volumes = %+v, want %+v", volumes, want)
+	}
+
+	if err := run.runner.RemoveVolume(context.Background(), "postgres-data", false); err == nil {
+		t.Error("only the runcontainer volumes should be removed")
+	}
+	if err := run.runner.RemoveVolume(context.Background(), "tgf-jdoe", false); err != nil {
+		t.Fatal(err)
+	}
+	if volume, _ := run.runner.InspectVolume(context.Background(), "tgf-jdoe"); !reflect.DeepEqual(cli.removed, []string{"tgf-jdoe"}) || !volume.LastUsed.IsZero() {
+		t.Errorf("removed %v, last use of the removed volume %v", cli.removed, volume.LastUsed)
+	}
+}
+
+func TestRunnerVolumeBackup(t *testing.T) {
+	run := newTestRun(t)
+	run.executor.Handler = func(ctx context.Context, command Command) error {
+		if strings.Contains(command.String(), "tar -cf") {
+			command.Stdout.Write([]byte("archive"))
+		}
+		return nil
+	}
+
+	var archive bytes.Buffer
+	if err := run.runner.BackupVolume(context.Background(), "tgf-jdoe", &archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.runner.RestoreVolume(context.Background(), "tgf-jdoe", &archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.String() != "archive" {
+		t.Errorf("archive = %q", archive.String())
+	}
+	if err := run.runner.BackupVolume(context.Background(), "postgres-data", &archive); err == nil {
+		t.Error("only the runcontainer volumes should be archived")
+	}
+
+	assertGolden(t, "volume_backup", run.commandLines(t))
+}
+
+func TestRunnerVolumeProfile(t *testing.T) {
+	cli := &volumeClient{volumes: []*types.Volume{{Name: "tgf-jdoe", Driver: "local"}}}
+	run := newTestRun(t, WithClient(cli), WithVolumeProfile(&DockerConfig{DockerHost: "ssh://builder@build-box"}))
+
+	if err := run.runner.BackupVolume(context.Background(), "tgf-jdoe", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.runner.RemoveVolume(context.Background(), "tgf-jdoe", false); err != nil {
+		t.Fatal(err)
+	}
+	if lines := run.executor.CommandLines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "docker --host ssh://builder@build-box run") {
+		t.Errorf("the volume is not archived on the daemon of the profile: %v", lines)
+	}
+
+	run = newTestRun(t, WithVolumeProfile(&DockerConfig{DockerHost: "tcp://build-box:2376", DockerTLSVerify: true}))
+	if _, err := run.runner.ListVolumes(context.Background()); err == nil {
+		t.Error("the invalid daemon of the profile should be rejected")
+	}
+}
+
+func TestHomeVolumeName(t *testing.T) {
+	profile := func(scope VolumeScope, name, image string) *DockerConfig {
+		return &DockerConfig{
+			Image:           image,
+			HomeVolumeScope: scope,
+			VolumePrefix:    "rc",
+			Environment:     map[string]string{"RUNCONTAINER_PROFILE": name, "RUNCONTAINER_CONFIGURATIONFILENAME": "/src/" + name + "/.runcontainer.json"},
+		}
+	}
+	name := func(config *DockerConfig) string {
+		volume, err := config.getHomeVolumeName("jdoe", "/src")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return volume
+	}
+
+	if volume := name(profile(VolumeScopeShared, "iac", "alpine")); volume != "rc-jdoe" {
+		t.Errorf("shared volume = %s, want rc-jdoe", volume)
+	}
+	for _, scope := range []VolumeScope{VolumeScopeProfile, VolumeScopeProject} {
+		iac, build := name(profile(scope, "iac", "alpine")), name(profile(scope, "build", "alpine"))
+		if !strings.HasPrefix(iac, "rc-jdoe-") || iac == build || iac != name(profile(scope, "iac", "golang")) {
+			t.Errorf("%s volumes are %s and %s", scope, iac, build)
+		}
+	}
+	alpine, golang := name(profile(VolumeScopeImage, "iac", "alpine")), name(profile(VolumeScopeImage, "iac", "golang"))
+	if alpine == golang || alpine != name(profile(VolumeScopeImage, "build", "alpine")) {
+		t.Errorf("image volumes are %s and %s", alpine, golang)
+	}
+
+	if _, err := profile("global", "iac", "alpine").getHomeVolumeName("jdoe", "/src"); err == nil {
+		t.Error("an unknown scope should be rejected")
+	}
+	invalid := profile(VolumeScopeShared, "iac", "alpine")
+	invalid.VolumePrefix = "my volumes"
+	if _, err := invalid.getHomeVolumeName("jdoe", "/src"); err == nil {
+		t.Error("an invalid prefix should be rejected")
+	}
+}
+
+func TestUpdateVolumeUsesConcurrent(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "volumes.json")
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 10; j++ {
+				name := fmt.Sprintf("tgf-%d-%d", i, j)
+				if err := updateVolumeUses(fileName, func(uses map[string]time.Time) { uses[name] = time.Now() }); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	uses, err := readVolumeUses(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uses) != 200 {
+		t.Errorf("%d uses recorded, want 200", len(uses))
+	}
+}